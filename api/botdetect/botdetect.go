@@ -0,0 +1,227 @@
+// Package botdetect scores how likely a hit was made by a bot, a crawler or a
+// mail security scanner rather than by a person.
+package botdetect
+
+import (
+	"container/list"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxScore is the score of a hit that is certainly not from a person.
+	MaxScore = 100
+
+	// hits within these delays after the mail was sent are likely made by a
+	// security gateway scanning it on delivery
+	sentVerySoon = 10 * time.Second
+	sentSoon     = time.Minute
+
+	// burstWindow and burstSize define a burst: more than burstSize hits on
+	// the same spy from the same network within burstWindow
+	burstWindow = time.Minute
+	burstSize   = 5
+)
+
+type userAgentRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Score   int    `json:"score"`
+
+	pattern *regexp.Regexp
+}
+
+type rangeRule struct {
+	Name  string   `json:"name"`
+	Cidrs []string `json:"cidrs"`
+	Score int      `json:"score"`
+
+	networks []*net.IPNet
+}
+
+type rules struct {
+	UserAgents []*userAgentRule `json:"user_agents"`
+	Ranges     []*rangeRule     `json:"ranges"`
+}
+
+//go:embed rules.json
+var rulesFile []byte
+
+var engine = mustLoad(rulesFile)
+
+func mustLoad(data []byte) *rules {
+	var r rules
+	if err := json.Unmarshal(data, &r); err != nil {
+		panic(fmt.Errorf("invalid bot rules: %w", err))
+	}
+
+	for _, rule := range r.UserAgents {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			panic(fmt.Errorf("invalid pattern of bot rule '%s': %w", rule.Name, err))
+		}
+		rule.pattern = re
+	}
+
+	for _, rule := range r.Ranges {
+		for _, cidr := range rule.Cidrs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				panic(fmt.Errorf("invalid range of bot rule '%s': %w", rule.Name, err))
+			}
+			rule.networks = append(rule.networks, network)
+		}
+	}
+
+	return &r
+}
+
+// Hit is what is known about a hit when it is scored.
+type Hit struct {
+	SpyID     uint // 0 when the spy is unknown, the bursts are then not counted
+	Ip        string
+	UserAgent string
+	Device    string // device class parsed from the user agent
+	Time      time.Time
+	SentAt    *time.Time // when the mail was sent, if known
+}
+
+// Score returns the bot score of a hit, from 0 (a person) to MaxScore, along
+// with the reasons of the score.
+func Score(hit Hit) (int, string) {
+	score := 0
+	var reasons []string
+
+	add := func(points int, reason string) {
+		score += points
+		reasons = append(reasons, reason)
+	}
+
+	if hit.UserAgent == "" {
+		add(30, "no user agent")
+	} else if hit.Device == "bot" {
+		add(60, "bot user agent")
+	}
+
+	for _, rule := range engine.UserAgents {
+		if rule.pattern.MatchString(hit.UserAgent) {
+			add(rule.Score, "scanner user agent: "+rule.Name)
+			break
+		}
+	}
+
+	if addr := net.ParseIP(hit.Ip); addr != nil {
+	ranges:
+		for _, rule := range engine.Ranges {
+			for _, network := range rule.networks {
+				if network.Contains(addr) {
+					add(rule.Score, "scanner network: "+rule.Name)
+					break ranges
+				}
+			}
+		}
+	}
+
+	if hit.SentAt != nil {
+		delay := hit.Time.Sub(*hit.SentAt)
+		switch {
+		case delay >= 0 && delay < sentVerySoon:
+			add(50, fmt.Sprintf("hit %s after send", delay.Round(time.Second)))
+		case delay >= 0 && delay < sentSoon:
+			add(25, fmt.Sprintf("hit %s after send", delay.Round(time.Second)))
+		}
+	}
+
+	if hit.SpyID != 0 {
+		if n := bursts.hit(hit.SpyID, hit.Ip, hit.Time); n > burstSize {
+			add(40, fmt.Sprintf("burst of %d hits from the network", n))
+		}
+	}
+
+	if score > MaxScore {
+		score = MaxScore
+	}
+
+	return score, strings.Join(reasons, ", ")
+}
+
+// network returns the network an address belongs to, a /24 in IPv4 and a /48
+// in IPv6, as hits of a scanner farm come from neighbouring addresses.
+func network(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ip
+	}
+
+	if v4 := addr.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return addr.Mask(net.CIDRMask(48, 128)).String()
+}
+
+type burstKey struct {
+	spyId   uint
+	network string
+}
+
+type burstEntry struct {
+	key   burstKey
+	times []time.Time
+}
+
+// burstTracker counts the recent hits per spy and network, forgetting the
+// least recently hit networks past maxTrackedNetworks.
+type burstTracker struct {
+	sync.Mutex
+	order *list.List
+	hits  map[burstKey]*list.Element
+}
+
+var bursts = newBurstTracker()
+
+func newBurstTracker() *burstTracker {
+	return &burstTracker{order: list.New(), hits: make(map[burstKey]*list.Element)}
+}
+
+// maxTrackedNetworks bounds the memory used by the tracker.
+const maxTrackedNetworks = 10000
+
+// hit records a hit and returns the number of hits from the same network on
+// the same spy within the burst window, this one included.
+func (t *burstTracker) hit(spyId uint, ip string, at time.Time) int {
+	t.Lock()
+	defer t.Unlock()
+
+	key := burstKey{spyId: spyId, network: network(ip)}
+	since := at.Add(-burstWindow)
+
+	element, ok := t.hits[key]
+	if ok {
+		t.order.MoveToFront(element)
+	} else {
+		element = t.order.PushFront(&burstEntry{key: key})
+		t.hits[key] = element
+		if t.order.Len() > maxTrackedNetworks {
+			oldest := t.order.Back()
+			t.order.Remove(oldest)
+			delete(t.hits, oldest.Value.(*burstEntry).key)
+		}
+	}
+
+	entry := element.Value.(*burstEntry)
+	recent := entry.times[:0]
+	for _, hitTime := range entry.times {
+		if hitTime.After(since) {
+			recent = append(recent, hitTime)
+		}
+	}
+	entry.times = append(recent, at)
+
+	return len(entry.times)
+}
@@ -0,0 +1,185 @@
+package botdetect
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const browser = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36"
+
+// resetBursts gives the test a tracker of its own.
+func resetBursts(t *testing.T) {
+	t.Helper()
+
+	previous := bursts
+	bursts = newBurstTracker()
+	t.Cleanup(func() { bursts = previous })
+}
+
+func TestScore(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(delay time.Duration) *time.Time {
+		sent := now.Add(-delay)
+		return &sent
+	}
+
+	tests := []struct {
+		name       string
+		hit        Hit
+		wantScore  int
+		wantReason string
+	}{
+		{
+			name:      "person",
+			hit:       Hit{SpyID: 1, Ip: "203.0.113.7", UserAgent: browser, Device: "desktop"},
+			wantScore: 0,
+		},
+		{
+			name:       "no user agent",
+			hit:        Hit{SpyID: 1, Ip: "203.0.113.7"},
+			wantScore:  30,
+			wantReason: "no user agent",
+		},
+		{
+			name:       "bot user agent",
+			hit:        Hit{SpyID: 1, Ip: "203.0.113.7", UserAgent: "Googlebot/2.1", Device: "bot"},
+			wantScore:  60,
+			wantReason: "bot user agent",
+		},
+		{
+			name:       "scanner user agent",
+			hit:        Hit{SpyID: 1, Ip: "203.0.113.7", UserAgent: "Mimecast Web Security", Device: "desktop"},
+			wantScore:  80,
+			wantReason: "scanner user agent: Mimecast",
+		},
+		{
+			name:       "http library",
+			hit:        Hit{SpyID: 1, Ip: "203.0.113.7", UserAgent: "python-requests/2.31", Device: "desktop"},
+			wantScore:  60,
+			wantReason: "scanner user agent: HTTP library",
+		},
+		{
+			name:       "scanner network",
+			hit:        Hit{SpyID: 1, Ip: "67.231.144.12", UserAgent: browser, Device: "desktop"},
+			wantScore:  70,
+			wantReason: "scanner network: Proofpoint",
+		},
+		{
+			name:       "hit right after send",
+			hit:        Hit{SpyID: 1, Ip: "203.0.113.7", UserAgent: browser, Device: "desktop", SentAt: ago(3 * time.Second)},
+			wantScore:  50,
+			wantReason: "hit 3s after send",
+		},
+		{
+			name:       "hit soon after send",
+			hit:        Hit{SpyID: 1, Ip: "203.0.113.7", UserAgent: browser, Device: "desktop", SentAt: ago(30 * time.Second)},
+			wantScore:  25,
+			wantReason: "hit 30s after send",
+		},
+		{
+			name:      "hit long after send",
+			hit:       Hit{SpyID: 1, Ip: "203.0.113.7", UserAgent: browser, Device: "desktop", SentAt: ago(time.Hour)},
+			wantScore: 0,
+		},
+		{
+			name:      "sent in the future",
+			hit:       Hit{SpyID: 1, Ip: "203.0.113.7", UserAgent: browser, Device: "desktop", SentAt: ago(-time.Hour)},
+			wantScore: 0,
+		},
+		{
+			name:       "capped",
+			hit:        Hit{SpyID: 1, Ip: "205.139.110.4", UserAgent: "Mimecast", Device: "bot", SentAt: ago(time.Second)},
+			wantScore:  MaxScore,
+			wantReason: "bot user agent, scanner user agent: Mimecast, scanner network: Mimecast, hit 1s after send",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetBursts(t)
+
+			hit := tt.hit
+			hit.Time = now
+			score, reason := Score(hit)
+			if score != tt.wantScore {
+				t.Errorf("Score() score = %d, want %d", score, tt.wantScore)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("Score() reason = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestScoreBurst(t *testing.T) {
+	resetBursts(t)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < burstSize; i++ {
+		if score, _ := Score(Hit{SpyID: 1, Ip: "203.0.113.7", UserAgent: browser, Time: now}); score != 0 {
+			t.Fatalf("Score() = %d for hit %d, under the burst size", score, i+1)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		hit       Hit
+		wantBurst bool
+	}{
+		{"same network", Hit{SpyID: 1, Ip: "203.0.113.200", Time: now}, true},
+		{"other network", Hit{SpyID: 1, Ip: "198.51.100.7", Time: now}, false},
+		{"other spy", Hit{SpyID: 2, Ip: "203.0.113.7", Time: now}, false},
+		{"after the window", Hit{SpyID: 1, Ip: "203.0.113.7", Time: now.Add(2 * burstWindow)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hit := tt.hit
+			hit.UserAgent = browser
+			_, reason := Score(hit)
+			if burst := strings.Contains(reason, "burst"); burst != tt.wantBurst {
+				t.Errorf("Score() reason = %q, want a burst %v", reason, tt.wantBurst)
+			}
+		})
+	}
+}
+
+func TestScoreUnknownSpy(t *testing.T) {
+	resetBursts(t)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2*burstSize; i++ {
+		if _, reason := Score(Hit{Ip: "203.0.113.7", UserAgent: browser, Time: now}); reason != "" {
+			t.Fatalf("Score() reason = %q for hit %d of an unknown spy", reason, i+1)
+		}
+	}
+	if n := bursts.order.Len(); n != 0 {
+		t.Errorf("tracker has %d networks, want the hits of unknown spies left out", n)
+	}
+}
+
+func TestBurstTrackerEviction(t *testing.T) {
+	tracker := newBurstTracker()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker.hit(1, "203.0.113.7", now)
+	tracker.hit(1, "203.0.113.7", now)
+	for i := 0; i < maxTrackedNetworks; i++ {
+		tracker.hit(uint(i+2), "198.51.100.7", now)
+		if i == maxTrackedNetworks/2 {
+			// a recent hit keeps the network tracked
+			tracker.hit(1, "203.0.113.7", now)
+		}
+	}
+
+	if n := tracker.order.Len(); n != maxTrackedNetworks || len(tracker.hits) != maxTrackedNetworks {
+		t.Fatalf("tracker has %d networks in order and %d in hits, want %d", n, len(tracker.hits), maxTrackedNetworks)
+	}
+	if n := tracker.hit(1, "203.0.113.7", now); n != 4 {
+		t.Errorf("hit() = %d for a recently hit network, want 4", n)
+	}
+	if n := tracker.hit(2, "198.51.100.7", now); n != 1 {
+		t.Errorf("hit() = %d for the least recently hit network, want it forgotten", n)
+	}
+}
@@ -0,0 +1,159 @@
+// Package clientip finds the address of the client of a request that went
+// through reverse proxies or load balancers.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Headers the client address can be read from.
+const (
+	XForwardedFor  = "X-Forwarded-For"
+	XRealIP        = "X-Real-Ip"
+	Forwarded      = "Forwarded"
+	CFConnectingIP = "Cf-Connecting-Ip"
+)
+
+var config struct {
+	sync.RWMutex
+	trusted []*net.IPNet
+	header  string
+}
+
+// Setup reads the comma separated list of trusted proxy ranges from the
+// TRUSTED_PROXIES env var, and the header they set from CLIENT_IP_HEADER
+// (X-Forwarded-For by default). Without trusted proxies, the address of the
+// peer is always used.
+func Setup() error {
+	var trusted []*net.IPNet
+	for _, cidr := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy '%s': %w", cidr, err)
+		}
+		trusted = append(trusted, network)
+	}
+
+	header := http.CanonicalHeaderKey(os.Getenv("CLIENT_IP_HEADER"))
+	switch header {
+	case "":
+		header = XForwardedFor
+	case XForwardedFor, XRealIP, Forwarded, CFConnectingIP:
+	default:
+		return fmt.Errorf("unsupported client ip header '%s'", header)
+	}
+
+	config.Lock()
+	config.trusted = trusted
+	config.header = header
+	config.Unlock()
+
+	return nil
+}
+
+func isTrusted(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, network := range config.trusted {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanAddr strips the port, quotes and brackets around an address.
+func cleanAddr(addr string) string {
+	addr = strings.Trim(strings.TrimSpace(addr), `"`)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// parseForwarded returns the "for" addresses of an RFC 7239 Forwarded header.
+func parseForwarded(value string) []string {
+	var hops []string
+	for _, element := range strings.Split(value, ",") {
+		for _, pair := range strings.Split(element, ";") {
+			key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				hops = append(hops, cleanAddr(val))
+			}
+		}
+	}
+	return hops
+}
+
+// Resolve returns the address of the client of a request coming from the peer
+// remoteIp, along with the forwarding chain, from the client to the peer, as
+// announced by the proxies. get returns the value of a request header.
+//
+// The chain is walked from right to left, skipping the trusted proxies, and
+// the first untrusted hop is the client: what is left of it may have been
+// forged by the client itself.
+func Resolve(remoteIp string, get func(header string) string) (string, []string) {
+	config.RLock()
+	defer config.RUnlock()
+
+	var hops []string
+	switch config.header {
+	case XForwardedFor:
+		for _, hop := range strings.Split(get(XForwardedFor), ",") {
+			if hop = cleanAddr(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	case Forwarded:
+		hops = parseForwarded(get(Forwarded))
+	default:
+		if hop := cleanAddr(get(config.header)); hop != "" {
+			hops = []string{hop}
+		}
+	}
+
+	if len(hops) == 0 {
+		return remoteIp, nil
+	}
+
+	chain := append(hops, remoteIp)
+	if !isTrusted(remoteIp) {
+		return remoteIp, chain
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			// an unknown or obfuscated hop can't be used, the last known
+			// address is the closest we get to the client
+			if i+1 < len(hops) {
+				return hops[i+1], chain
+			}
+			return remoteIp, chain
+		}
+		if !isTrusted(hops[i]) || i == 0 {
+			return hops[i], chain
+		}
+	}
+
+	return remoteIp, chain
+}
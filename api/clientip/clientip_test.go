@@ -0,0 +1,157 @@
+package clientip
+
+import (
+	"reflect"
+	"testing"
+)
+
+func setup(t *testing.T, trusted string, header string) {
+	t.Helper()
+
+	t.Setenv("TRUSTED_PROXIES", trusted)
+	t.Setenv("CLIENT_IP_HEADER", header)
+	if err := Setup(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted string
+		header  string
+		wantErr bool
+	}{
+		{"defaults", "", "", false},
+		{"ranges and addresses", "10.0.0.0/8, 192.168.1.1, ::1", "X-Real-IP", false},
+		{"invalid range", "10.0.0.0/33", "", true},
+		{"invalid address", "proxy", "", true},
+		{"unsupported header", "", "X-Client-IP", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.trusted)
+			t.Setenv("CLIENT_IP_HEADER", tt.header)
+			if err := Setup(); (err != nil) != tt.wantErr {
+				t.Errorf("Setup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		trusted   string
+		header    string
+		remoteIp  string
+		headers   map[string]string
+		wantIp    string
+		wantChain []string
+	}{
+		{
+			name:     "no proxy",
+			trusted:  "10.0.0.0/8",
+			remoteIp: "203.0.113.7",
+			wantIp:   "203.0.113.7",
+		},
+		{
+			name:      "untrusted peer",
+			trusted:   "10.0.0.0/8",
+			remoteIp:  "203.0.113.7",
+			headers:   map[string]string{XForwardedFor: "198.51.100.1"},
+			wantIp:    "203.0.113.7",
+			wantChain: []string{"198.51.100.1", "203.0.113.7"},
+		},
+		{
+			name:      "no trusted proxies",
+			remoteIp:  "10.0.0.1",
+			headers:   map[string]string{XForwardedFor: "198.51.100.1"},
+			wantIp:    "10.0.0.1",
+			wantChain: []string{"198.51.100.1", "10.0.0.1"},
+		},
+		{
+			name:      "trusted peer",
+			trusted:   "10.0.0.0/8",
+			remoteIp:  "10.0.0.1",
+			headers:   map[string]string{XForwardedFor: "198.51.100.1"},
+			wantIp:    "198.51.100.1",
+			wantChain: []string{"198.51.100.1", "10.0.0.1"},
+		},
+		{
+			name:      "forged hops left of the client",
+			trusted:   "10.0.0.0/8",
+			remoteIp:  "10.0.0.1",
+			headers:   map[string]string{XForwardedFor: "1.2.3.4, 198.51.100.1, 10.0.0.2"},
+			wantIp:    "198.51.100.1",
+			wantChain: []string{"1.2.3.4", "198.51.100.1", "10.0.0.2", "10.0.0.1"},
+		},
+		{
+			name:      "only trusted hops",
+			trusted:   "10.0.0.0/8",
+			remoteIp:  "10.0.0.1",
+			headers:   map[string]string{XForwardedFor: "10.0.0.3, 10.0.0.2"},
+			wantIp:    "10.0.0.3",
+			wantChain: []string{"10.0.0.3", "10.0.0.2", "10.0.0.1"},
+		},
+		{
+			name:      "obfuscated hop",
+			trusted:   "10.0.0.0/8",
+			remoteIp:  "10.0.0.1",
+			headers:   map[string]string{XForwardedFor: "unknown, 10.0.0.2"},
+			wantIp:    "10.0.0.2",
+			wantChain: []string{"unknown", "10.0.0.2", "10.0.0.1"},
+		},
+		{
+			name:      "obfuscated last hop",
+			trusted:   "10.0.0.0/8",
+			remoteIp:  "10.0.0.1",
+			headers:   map[string]string{XForwardedFor: "198.51.100.1, unknown"},
+			wantIp:    "10.0.0.1",
+			wantChain: []string{"198.51.100.1", "unknown", "10.0.0.1"},
+		},
+		{
+			name:      "ports and brackets",
+			trusted:   "10.0.0.0/8",
+			remoteIp:  "10.0.0.1",
+			headers:   map[string]string{XForwardedFor: "[2001:db8::1]:4711"},
+			wantIp:    "2001:db8::1",
+			wantChain: []string{"2001:db8::1", "10.0.0.1"},
+		},
+		{
+			name:      "forwarded header",
+			trusted:   "10.0.0.0/8",
+			header:    Forwarded,
+			remoteIp:  "10.0.0.1",
+			headers:   map[string]string{Forwarded: `for=198.51.100.1;proto=https, for="[2001:db8::2]:80";by=10.0.0.2`},
+			wantIp:    "2001:db8::2",
+			wantChain: []string{"198.51.100.1", "2001:db8::2", "10.0.0.1"},
+		},
+		{
+			name:      "single address header",
+			trusted:   "10.0.0.1",
+			header:    XRealIP,
+			remoteIp:  "10.0.0.1",
+			headers:   map[string]string{XRealIP: "198.51.100.1", XForwardedFor: "1.2.3.4"},
+			wantIp:    "198.51.100.1",
+			wantChain: []string{"198.51.100.1", "10.0.0.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t, tt.trusted, tt.header)
+
+			ip, chain := Resolve(tt.remoteIp, func(header string) string {
+				return tt.headers[header]
+			})
+			if ip != tt.wantIp {
+				t.Errorf("Resolve() ip = %q, want %q", ip, tt.wantIp)
+			}
+			if !reflect.DeepEqual(chain, tt.wantChain) {
+				t.Errorf("Resolve() chain = %q, want %q", chain, tt.wantChain)
+			}
+		})
+	}
+}
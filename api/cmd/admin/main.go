@@ -0,0 +1,175 @@
+// Command admin runs maintenance tasks against the database of the API.
+//
+//	go run ./cmd/admin <command>
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/geoip"
+	"github.com/ZiplEix/pixel-espion/mailproxy"
+	"github.com/ZiplEix/pixel-espion/services"
+	"github.com/ZiplEix/pixel-espion/spool"
+	"github.com/joho/godotenv"
+)
+
+type command struct {
+	description string
+	run         func() error
+	offline     bool // runs without connecting to the database
+}
+
+var commands = map[string]command{
+	"geoip-backfill": {
+		description: "locate the records stored without a location",
+		run:         geoipBackfill,
+	},
+	"partition-maintain": {
+		description: "create the coming record partitions and drop the expired ones",
+		run:         partitionMaintain,
+	},
+	"rollup-rebuild": {
+		description: "recompute the hourly and daily rollups from the records",
+		run:         rollupRebuild,
+	},
+	"spool-status": {
+		description: "list the spool files waiting to be replayed",
+		run:         spoolStatus,
+		offline:     true,
+	},
+	"spool-replay": {
+		description: "store the records of the rotated spool files",
+		run:         spoolReplay,
+	},
+}
+
+func usage() {
+	fmt.Println("usage: admin <command>")
+	fmt.Println()
+	fmt.Println("commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("  %-16s %s\n", name, commands[name].description)
+	}
+}
+
+func geoipBackfill() error {
+	if err := geoip.Setup(); err != nil {
+		return err
+	}
+
+	updated, err := services.BackfillGeo()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d records located\n", updated)
+	return nil
+}
+
+func partitionMaintain() error {
+	dropped, err := services.MaintainPartitions()
+	for _, name := range dropped {
+		fmt.Printf("%s dropped\n", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("partitions up to date")
+	return nil
+}
+
+func rollupRebuild() error {
+	if err := services.RebuildRollups(); err != nil {
+		return err
+	}
+
+	fmt.Println("rollups rebuilt")
+	return nil
+}
+
+func spoolStatus() error {
+	files, err := spool.Status()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("spool directory: %s\n", spool.Dir())
+	if len(files) == 0 {
+		fmt.Println("nothing to replay")
+		return nil
+	}
+
+	entries := 0
+	for _, file := range files {
+		state := "rotated"
+		if file.Current {
+			state = "current"
+		}
+		fmt.Printf("  %-26s %-8s %8d entries %10d bytes  %s\n",
+			file.Name, state, file.Entries, file.Size, file.ModTime.Format(time.RFC3339))
+		entries += file.Entries
+	}
+	fmt.Printf("%d entries in %d files\n", entries, len(files))
+
+	return nil
+}
+
+func spoolReplay() error {
+	// the records are enriched the way the server does
+	if err := geoip.Setup(); err != nil {
+		return err
+	}
+	if err := mailproxy.Setup(); err != nil {
+		return err
+	}
+
+	// the current file is left to the server writing to it, which rotates it
+	// once the database is back
+	replayed, err := services.ReplaySpool()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d records replayed\n", replayed)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	// the .env file is optional here, the environment may be set by the caller
+	_ = godotenv.Load()
+
+	if !cmd.offline {
+		if err := database.Connect(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if err := cmd.run(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
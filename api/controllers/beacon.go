@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/ZiplEix/pixel-espion/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// BeaconScript godoc
+// @Summary Get the beacon script of a spy
+// @Description Returns the JavaScript snippet that reports page views of a website to the beacon endpoint of the spy.
+// @Tags beacon
+// @Produce  application/javascript
+// @Param token path string true "Spy token"
+// @Success 200 {string} string "Beacon script"
+// @Failure 404 {object} errorResponse "Not Found: Spy not found"
+// @Failure 500 {object} errorResponse "Internal Server Error"
+// @Router /b/{token}.js [get]
+func BeaconScript(c *fiber.Ctx) error {
+	script, err := services.BeaconScript(c.Params("token"))
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/javascript; charset=utf-8")
+	return c.Send(script)
+}
+
+// Beacon godoc
+// @Summary Receive a beacon
+// @Description Logs a beacon sent by navigator.sendBeacon from the beacon script of a spy.
+// @Description The body is a JSON object, sent either as text/plain or application/json.
+// @Tags beacon
+// @Accept  plain,json
+// @Param token path string true "Spy token"
+// @Success 204 "No Content"
+// @Failure 400 {object} errorResponse "Bad Request: Invalid payload"
+// @Failure 404 {object} errorResponse "Not Found: Spy not found"
+// @Failure 413 {object} errorResponse "Payload Too Large"
+// @Failure 415 {object} errorResponse "Unsupported Media Type"
+// @Failure 429 {object} errorResponse "Too Many Requests"
+// @Failure 500 {object} errorResponse "Internal Server Error"
+// @Router /b/{token} [post]
+func Beacon(c *fiber.Ctx) error {
+	contentType := strings.ToLower(c.Get(fiber.HeaderContentType))
+	if !strings.HasPrefix(contentType, fiber.MIMETextPlain) && !strings.HasPrefix(contentType, fiber.MIMEApplicationJSON) {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(errorResponse{
+			Error: "Beacon must be sent as text/plain or application/json",
+		})
+	}
+
+	err := services.Beacon(c.Params("token"), requestContext(c), c.Body())
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"io"
+
+	"github.com/ZiplEix/pixel-espion/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// UploadSpyImage godoc
+// @Summary Upload the image of a spy
+// @Description Uploads the image served instead of the generated pixel of a spy (PNG, GIF, JPEG or WebP, up to 1MB),
+// @Description only if the user is the owner
+// @Tags spies
+// @Accept multipart/form-data
+// @Param id path string true "Spy ID"
+// @Param image formData file true "Image"
+// @Success 204 "No Content"
+// @Failure 400 {object} fiber.Map{error=string} "Bad Request"
+// @Failure 403 {object} fiber.Map{error=string} "Unauthorized"
+// @Failure 404 {object} fiber.Map{error=string} "Spy Not Found"
+// @Failure 413 {object} fiber.Map{error=string} "Image Too Large"
+// @Failure 415 {object} fiber.Map{error=string} "Unsupported Image Type"
+// @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
+// @Router /spy/{id}/image [post]
+func UploadSpyImage(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	spyId := c.Params("id")
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	if file.Size > services.SpyImageMaxSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(errorResponse{
+			Error: "Image is too large",
+		})
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, services.SpyImageMaxSize+1))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	err = services.UploadSpyImage(spyId, data, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// DeleteSpyImage godoc
+// @Summary Delete the image of a spy
+// @Description Deletes the uploaded image of a spy, which then serves its generated pixel again,
+// @Description only if the user is the owner
+// @Tags spies
+// @Param id path string true "Spy ID"
+// @Success 204 "No Content"
+// @Failure 403 {object} fiber.Map{error=string} "Unauthorized"
+// @Failure 404 {object} fiber.Map{error=string} "Spy Not Found"
+// @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
+// @Router /spy/{id}/image [delete]
+func DeleteSpyImage(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	spyId := c.Params("id")
+
+	err := services.DeleteSpyImage(spyId, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
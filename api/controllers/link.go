@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+	"github.com/ZiplEix/pixel-espion/services"
+	"github.com/ZiplEix/pixel-espion/validation"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Click godoc
+// @Summary Follow a tracked link
+// @Description Logs the click on a tracked link and redirects to its destination url.
+// @Tags link
+// @Param token path string true "Link token"
+// @Success 302 "Redirect to the destination url"
+// @Failure 404 {object} errorResponse "Not Found: Link not found"
+// @Failure 500 {object} errorResponse "Internal Server Error"
+// @Router /l/{token} [get]
+func Click(c *fiber.Ctx) error {
+	token := c.Params("token")
+
+	url, err := services.Click(token, requestContext(c))
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Redirect(url, fiber.StatusFound)
+}
+
+// NewLink godoc
+// @Summary Create a new tracked link
+// @Description Creates a tracked link under a spy of the authenticated user
+// @Tags links
+// @Accept json
+// @Produce json
+// @Param NewLinkRequest body requestmodels.NewLinkRequest true "Link information"
+// @Success 201 {object} fiber.Map{link_id=int,token=string} "Created"
+// @Failure 400 {object} fiber.Map{error=string} "Bad Request"
+// @Failure 403 {object} fiber.Map{error=string} "Unauthorized"
+// @Failure 404 {object} fiber.Map{error=string} "Spy Not Found"
+// @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
+// @Router /link/new [post]
+func NewLink(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var req requestmodels.NewLinkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err := validation.NewLink(req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	link, err := services.NewLink(req, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"link_id": link.ID,
+		"token":   link.Token,
+	})
+}
+
+// GetAllLinks godoc
+// @Summary Retrieve all links for the authenticated user
+// @Description Returns the tracked links of all the spies of the authenticated user
+// @Tags links
+// @Produce json
+// @Success 200 {object} fiber.Map{links=[]models.Link} "List of links"
+// @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
+// @Router /link/all [get]
+func GetAllLinks(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	links, err := services.GetAllLinks(userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"links": links,
+	})
+}
+
+// GetLink godoc
+// @Summary Retrieve a link by ID
+// @Description Returns the details of a tracked link, only if the user is the owner
+// @Tags links
+// @Produce json
+// @Param id path string true "Link ID"
+// @Success 200 {object} models.Link "Link details"
+// @Failure 403 {object} fiber.Map{error=string} "Unauthorized"
+// @Failure 404 {object} fiber.Map{error=string} "Link not found"
+// @Router /link/{id} [get]
+func GetLink(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	linkId := c.Params("id")
+
+	link, err := services.GetLink(linkId, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(link)
+}
+
+// UpdateLink godoc
+// @Summary Update a link's name and destination
+// @Description Updates the name and destination url of a tracked link, only if the user is the owner
+// @Tags links
+// @Accept json
+// @Produce json
+// @Param id path string true "Link ID"
+// @Param link body requestmodels.UpdateLinkRequest true "Link update details"
+// @Success 204 "No Content"
+// @Failure 400 {object} fiber.Map{error=string} "Bad Request"
+// @Failure 403 {object} fiber.Map{error=string} "Unauthorized"
+// @Failure 404 {object} fiber.Map{error=string} "Link Not Found"
+// @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
+// @Router /link/{id} [put]
+func UpdateLink(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	linkId := c.Params("id")
+
+	var req requestmodels.UpdateLinkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err := validation.UpdateLink(req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	err = services.UpdateLink(linkId, req, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// DeleteLink godoc
+// @Summary Delete a link
+// @Description Deletes a tracked link, only if the user is the owner
+// @Tags links
+// @Param id path string true "Link ID"
+// @Success 204 "No Content"
+// @Failure 403 {object} fiber.Map{error=string} "Unauthorized"
+// @Failure 404 {object} fiber.Map{error=string} "Link Not Found"
+// @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
+// @Router /link/{id} [delete]
+func DeleteLink(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	linkId := c.Params("id")
+
+	err := services.DeleteLink(linkId, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -1,5 +1,39 @@
 package controllers
 
+import (
+	"github.com/ZiplEix/pixel-espion/clientip"
+	"github.com/ZiplEix/pixel-espion/services"
+	"github.com/gofiber/fiber/v2"
+)
+
 type errorResponse struct {
 	Error string `json:"error"`
 }
+
+// requestContext gathers what the services record about a tracking request.
+func requestContext(c *fiber.Ctx) services.RequestContext {
+	ip, chain := clientip.Resolve(c.IP(), func(header string) string {
+		return c.Get(header)
+	})
+
+	req := services.RequestContext{
+		Ip:             ip,
+		ForwardedChain: chain,
+		UserAgent:      c.Get(fiber.HeaderUserAgent),
+		Referer:        c.Get(fiber.HeaderReferer),
+		AcceptLanguage: c.Get(fiber.HeaderAcceptLanguage),
+		Host:           c.Hostname(),
+		Query:          c.Queries(),
+	}
+
+	for _, name := range services.RecordedHeaders() {
+		if value := c.Get(name); value != "" {
+			if req.Headers == nil {
+				req.Headers = make(map[string]string)
+			}
+			req.Headers[name] = value
+		}
+	}
+
+	return req
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+	"github.com/ZiplEix/pixel-espion/services"
+	"github.com/ZiplEix/pixel-espion/validation"
+	"github.com/gofiber/fiber/v2"
+)
+
+// AttachRecipients godoc
+// @Summary Attach recipients to a spy
+// @Description Adds up to 1000 recipients to a spy, only if the user is the owner. Recipients already
+// @Description attached with the same email are updated.
+// @Tags recipients
+// @Accept json
+// @Produce json
+// @Param id path string true "Spy ID"
+// @Param recipients body requestmodels.AttachRecipientsRequest true "Recipients"
+// @Success 200 {object} fiber.Map{recipients=[]models.Recipient} "Attached recipients"
+// @Failure 400 {object} errorResponse "Bad Request"
+// @Failure 403 {object} errorResponse "Unauthorized"
+// @Failure 404 {object} errorResponse "Spy Not Found"
+// @Failure 500 {object} errorResponse "Internal Server Error"
+// @Router /spy/{id}/recipients [post]
+func AttachRecipients(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	spyId := c.Params("id")
+
+	var req requestmodels.AttachRecipientsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	err := validation.AttachRecipients(req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	recipients, err := services.AttachRecipients(spyId, req, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"recipients": recipients,
+	})
+}
+
+// GetSpyRecipients godoc
+// @Summary Retrieve the recipients of a spy
+// @Description Returns the recipients of a spy with their open count and first and last open, only if the
+// @Description user is the owner. Suspicious records are not counted.
+// @Tags recipients
+// @Produce json
+// @Param id path string true "Spy ID"
+// @Param opened query bool false "Only the recipients who opened the spy (true) or who didn't (false)"
+// @Success 200 {object} fiber.Map{recipients=[]services.RecipientStatus} "Recipients of the spy"
+// @Failure 400 {object} errorResponse "Bad Request"
+// @Failure 403 {object} errorResponse "Unauthorized"
+// @Failure 404 {object} errorResponse "Spy Not Found"
+// @Failure 500 {object} errorResponse "Internal Server Error"
+// @Router /spy/{id}/recipients [get]
+func GetSpyRecipients(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	spyId := c.Params("id")
+
+	var filter requestmodels.RecipientFilter
+	if err := c.QueryParser(&filter); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	recipients, err := services.GetSpyRecipients(spyId, filter, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"recipients": recipients,
+	})
+}
+
+// RecipientUrls godoc
+// @Summary Mint the pixel url of each recipient of a spy
+// @Description Returns a signed pixel url per recipient of a spy, only if the user is the owner.
+// @Description The records of these urls resolve to their recipient.
+// @Tags recipients
+// @Produce json
+// @Param id path string true "Spy ID"
+// @Param campaign query string false "Campaign carried by the urls"
+// @Param format query string false "Image format (png, gif, webp, svg)"
+// @Success 200 {object} fiber.Map{urls=[]services.RecipientUrl} "Pixel url per recipient"
+// @Failure 400 {object} errorResponse "Bad Request"
+// @Failure 403 {object} errorResponse "Unauthorized"
+// @Failure 404 {object} errorResponse "Spy Not Found"
+// @Failure 500 {object} errorResponse "Internal Server Error"
+// @Router /spy/{id}/recipients/urls [get]
+func RecipientUrls(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	spyId := c.Params("id")
+
+	var req requestmodels.RecipientUrlsRequest
+	if err := c.QueryParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	err := validation.RecipientUrls(req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	urls, err := services.RecipientUrls(spyId, req, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"urls": urls,
+	})
+}
+
+// DeleteRecipient godoc
+// @Summary Remove a recipient from a spy
+// @Description Removes a recipient from a spy, only if the user is the owner. Its records are kept.
+// @Tags recipients
+// @Param id path string true "Spy ID"
+// @Param recipientId path string true "Recipient ID"
+// @Success 204 "No Content"
+// @Failure 403 {object} errorResponse "Unauthorized"
+// @Failure 404 {object} errorResponse "Spy or Recipient Not Found"
+// @Failure 500 {object} errorResponse "Internal Server Error"
+// @Router /spy/{id}/recipients/{recipientId} [delete]
+func DeleteRecipient(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	spyId := c.Params("id")
+	recipientId := c.Params("recipientId")
+
+	err := services.DeleteRecipient(spyId, recipientId, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
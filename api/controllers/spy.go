@@ -1,41 +1,125 @@
 package controllers
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"time"
+
 	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
 	"github.com/ZiplEix/pixel-espion/services"
 	"github.com/ZiplEix/pixel-espion/validation"
 	"github.com/gofiber/fiber/v2"
 )
 
+// pixelFormat picks the format of the pixel from the path extension, or from
+// the Accept header when there is none.
+func pixelFormat(c *fiber.Ctx) (services.PixelFormat, bool) {
+	if ext := c.Params("ext"); ext != "" {
+		return services.ParsePixelFormat(ext)
+	}
+
+	return services.PixelFormatFromContentType(c.Accepts(services.PixelContentTypes...)), true
+}
+
+// isPrefetch tells whether the pixel is only being checked, by a HEAD request
+// or a cache revalidation, rather than actually loaded.
+func isPrefetch(c *fiber.Ctx) bool {
+	return c.Method() == fiber.MethodHead ||
+		c.Get(fiber.HeaderIfNoneMatch) != "" ||
+		c.Get(fiber.HeaderIfModifiedSince) != ""
+}
+
+// noCache sets the headers keeping browsers and intermediaries from caching
+// the pixel, so that every open reaches us. The ETag changes on every response
+// so that a revalidation never matches.
+func noCache(c *fiber.Ctx) {
+	etag := make([]byte, 8)
+	if _, err := rand.Read(etag); err != nil {
+		binary.BigEndian.PutUint64(etag, uint64(time.Now().UnixNano()))
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store, no-cache, must-revalidate, private, max-age=0")
+	c.Set(fiber.HeaderPragma, "no-cache")
+	c.Set(fiber.HeaderExpires, "0")
+	c.Set(fiber.HeaderETag, `"`+hex.EncodeToString(etag)+`"`)
+	c.Set(fiber.HeaderVary, "Accept")
+}
+
+func sendPixel(c *fiber.Ctx, token string) error {
+	format, ok := pixelFormat(c)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(errorResponse{
+			Error: "Unsupported pixel format",
+		})
+	}
+
+	img, contentType, err := services.Pixel1(token, requestContext(c), format, isPrefetch(c))
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	noCache(c)
+	// an uploaded image keeps its own type, whatever the extension requested
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderContentLength, strconv.Itoa(len(img)))
+	return c.Send(img)
+}
+
 // Pixel1 godoc
 // @Summary Get Spy Image
-// @Description Retrieve an image associated with a spy by their ID and log the visit record.
+// @Description Retrieve the pixel of a spy, rendered in the spy color, by their token and log the visit record.
+// @Description The image format is negotiated from the Accept header (PNG by default), unless the spy has an uploaded image.
+// @Description Per-recipient fields signed by /spy/{id}/url are checked and stored on the record.
+// @Description HEAD and conditional requests are logged as prefetch records.
 // @Tags spy
 // @Accept  json
-// @Produce  png
-// @Param id query string true "Spy ID"
+// @Produce  png,gif,image/webp,image/svg+xml
+// @Param token query string true "Spy token"
+// @Param r query string false "Recipient (signed)"
+// @Param c query string false "Campaign (signed)"
+// @Param t query int false "Send timestamp (signed)"
+// @Param sig query string false "Signature of the recipient fields"
 // @Success 200 {file} file "Returns the spy image"
-// @Failure 400 {object} errorResponse "Bad Request: Spy ID is required"
+// @Failure 400 {object} errorResponse "Bad Request: Spy token is required"
 // @Failure 404 {object} errorResponse "Not Found: Spy not found"
 // @Failure 500 {object} errorResponse "Internal Server Error"
 // @Router /spy/pixel1 [get]
 func Pixel1(c *fiber.Ctx) error {
-	spyId := c.Query("id")
-	if spyId == "" {
+	token := c.Query("token")
+	if token == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
-			Error: "Spy ID is required",
+			Error: "Spy token is required",
 		})
 	}
 
-	imagePath, err := services.Pixel1(spyId, c.IP())
-	if err != nil {
-		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
-			Error: err.Error(),
-		})
-	}
+	return sendPixel(c, token)
+}
 
-	c.Set("Content-Type", "image/png")
-	return c.SendFile(imagePath)
+// Pixel godoc
+// @Summary Get Spy Image in a given format
+// @Description Retrieve the pixel of a spy and log the visit record. The image format is taken from
+// @Description the path extension (gif, png, webp or svg), or negotiated from the Accept header when there is none.
+// @Description The image uploaded for the spy, if any, is served as is instead.
+// @Description HEAD and conditional requests are logged as prefetch records.
+// @Tags spy
+// @Produce  png,gif,image/webp,image/svg+xml
+// @Param token path string true "Spy token"
+// @Param ext path string false "Image format (gif, png, webp, svg)"
+// @Param r query string false "Recipient (signed)"
+// @Param c query string false "Campaign (signed)"
+// @Param t query int false "Send timestamp (signed)"
+// @Param sig query string false "Signature of the recipient fields"
+// @Success 200 {file} file "Returns the spy image"
+// @Failure 404 {object} errorResponse "Not Found: Spy not found or unsupported format"
+// @Failure 500 {object} errorResponse "Internal Server Error"
+// @Router /p/{token}.{ext} [get]
+func Pixel(c *fiber.Ctx) error {
+	return sendPixel(c, c.Params("token"))
 }
 
 // NewSpy godoc
@@ -45,7 +129,7 @@ func Pixel1(c *fiber.Ctx) error {
 // @Accept json
 // @Produce json
 // @Param NewSpyRequest body requestmodels.NewSpyRequest true "Spy information"
-// @Success 201 {object} fiber.Map{spy_id=int} "Created"
+// @Success 201 {object} fiber.Map{spy_id=int,token=string} "Created"
 // @Failure 400 {object} fiber.Map{error=string} "Bad Request"
 // @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
 // @Router /spy/new [post]
@@ -59,7 +143,14 @@ func NewSpy(c *fiber.Ctx) error {
 		})
 	}
 
-	spyId, err := services.NewSpy(req, userId)
+	err := validation.NewSpy(req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	spy, err := services.NewSpy(req, userId)
 	if err != nil {
 		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
 			Error: err.Error(),
@@ -67,7 +158,8 @@ func NewSpy(c *fiber.Ctx) error {
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"spy_id": spyId,
+		"spy_id": spy.ID,
+		"token":  spy.Token,
 	})
 }
 
@@ -96,18 +188,20 @@ func GetAllSpies(c *fiber.Ctx) error {
 
 // GetSpy godoc
 // @Summary Retrieve a spy by ID
-// @Description Returns the details of a specific spy based on the provided ID
+// @Description Returns the details of a specific spy based on the provided ID, only if the user is the owner, with its total and unique open counts
 // @Tags spies
 // @Produce json
 // @Param id path string true "Spy ID"
 // @Success 200 {object} models.Spy "Spy details"
+// @Failure 403 {object} fiber.Map{error=string} "Unauthorized"
 // @Failure 404 {object} fiber.Map{error=string} "Spy not found"
 // @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
 // @Router /spy/{id} [get]
 func GetSpy(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
 	spyId := c.Params("id")
 
-	spy, err := services.GetSpy(spyId)
+	spy, err := services.GetSpy(spyId, userId)
 	if err != nil {
 		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
 			Error: err.Error(),
@@ -119,27 +213,76 @@ func GetSpy(c *fiber.Ctx) error {
 
 // GetSpyRecords godoc
 // @Summary Retrieve records of a specific spy
-// @Description Returns all records associated with a specific spy based on the provided spy ID
+// @Description Returns all records associated with a specific spy based on the provided spy ID,
+// @Description along with the click count of each of its links. With group_by, the record counts per value
+// @Description of the given field are returned as well. Suspicious records are left out of the counts.
 // @Tags records
 // @Produce json
 // @Param id path string true "Spy ID"
-// @Success 200 {object} fiber.Map{records=[]models.Record} "List of records for the spy"
+// @Param type query string false "Event type (open, prefetch, click, beacon, canary)"
+// @Param browser query string false "Browser family"
+// @Param os query string false "Operating system"
+// @Param device query string false "Device class (desktop, mobile, tablet, bot)"
+// @Param email_client query string false "Email client"
+// @Param proxy query string false "Mail proxy class (direct, proxied, prefetched)"
+// @Param exclude_proxied query bool false "Leave out the records of mail proxies"
+// @Param exclude_suspicious query bool false "Leave out the records flagged as bots"
+// @Param visit query string false "Visit of the record (first, repeat, reopen)"
+// @Param group_by query string false "Count the records by browser, os, device, email_client or proxy"
+// @Success 200 {object} fiber.Map{records=[]models.Record,links=[]services.LinkStats,groups=[]services.RecordGroup} "List of records and link clicks for the spy"
+// @Failure 400 {object} fiber.Map{error=string} "Bad Request"
+// @Failure 403 {object} fiber.Map{error=string} "Unauthorized"
 // @Failure 404 {object} fiber.Map{error=string} "Spy not found"
 // @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
 // @Router /record/spy/{id} [get]
 func GetSpyRecords(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
 	spyId := c.Params("id")
 
-	records, err := services.GetSpyRecords(spyId)
+	var filter requestmodels.RecordFilter
+	if err := c.QueryParser(&filter); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	err := validation.RecordFilter(filter)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	records, err := services.GetSpyRecords(spyId, userId, filter)
 	if err != nil {
 		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
 			Error: err.Error(),
 		})
 	}
 
-	return c.JSON(fiber.Map{
+	links, err := services.GetSpyLinkStats(spyId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	res := fiber.Map{
 		"records": records,
-	})
+		"links":   links,
+	}
+
+	if filter.GroupBy != "" {
+		groups, err := services.GroupSpyRecords(spyId, filter)
+		if err != nil {
+			return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+				Error: err.Error(),
+			})
+		}
+		res["groups"] = groups
+	}
+
+	return c.JSON(res)
 }
 
 // GetAllRecords godoc
@@ -147,13 +290,37 @@ func GetSpyRecords(c *fiber.Ctx) error {
 // @Description Returns all records associated with the user's spies
 // @Tags records
 // @Produce json
+// @Param type query string false "Event type (open, prefetch, click, beacon, canary)"
+// @Param browser query string false "Browser family"
+// @Param os query string false "Operating system"
+// @Param device query string false "Device class (desktop, mobile, tablet, bot)"
+// @Param email_client query string false "Email client"
+// @Param proxy query string false "Mail proxy class (direct, proxied, prefetched)"
+// @Param exclude_proxied query bool false "Leave out the records of mail proxies"
+// @Param exclude_suspicious query bool false "Leave out the records flagged as bots"
+// @Param visit query string false "Visit of the record (first, repeat, reopen)"
 // @Success 200 {object} fiber.Map{records=[]models.Record} "List of records for the user"
+// @Failure 400 {object} fiber.Map{error=string} "Bad Request"
 // @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
 // @Router /record/all [get]
 func GetAllRecords(c *fiber.Ctx) error {
 	userId := c.Locals("userId").(uint)
 
-	records, err := services.GetAllRecords(userId)
+	var filter requestmodels.RecordFilter
+	if err := c.QueryParser(&filter); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	err := validation.RecordFilter(filter)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	records, err := services.GetAllRecords(userId, filter)
 	if err != nil {
 		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
 			Error: err.Error(),
@@ -166,13 +333,14 @@ func GetAllRecords(c *fiber.Ctx) error {
 }
 
 // UpdateSpy godoc
-// @Summary Update a spy's name and color
-// @Description Updates the name and color of a spy specified by ID, only if the user is the owner
+// @Summary Update a spy's name, color and pixel settings
+// @Description Updates the name, color and pixel settings of a spy specified by ID, only if the user is the owner.
+// @Description The fields left out of the request keep their value.
 // @Tags spies
 // @Accept json
 // @Produce json
 // @Param id path string true "Spy ID"
-// @Param spy body requestmodels.NewSpyRequest true "Spy update details"
+// @Param spy body requestmodels.UpdateSpyRequest true "Spy update details"
 // @Success 204 "No Content"
 // @Failure 400 {object} fiber.Map{error=string} "Bad Request"
 // @Failure 403 {object} fiber.Map{error=string} "Unauthorized"
@@ -183,14 +351,14 @@ func UpdateSpy(c *fiber.Ctx) error {
 	userId := c.Locals("userId").(uint)
 	spyId := c.Params("id")
 
-	var req requestmodels.NewSpyRequest
+	var req requestmodels.UpdateSpyRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
-	err := validation.NewSpy(req)
+	err := validation.UpdateSpy(req)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
 			Error: err.Error(),
@@ -207,6 +375,79 @@ func UpdateSpy(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
+// RotateSpyToken godoc
+// @Summary Rotate the token of a spy
+// @Description Replaces the public token used in the pixel urls of a spy, only if the user is the owner.
+// @Description Pixels embedded with the previous token stop being tracked.
+// @Tags spies
+// @Produce json
+// @Param id path string true "Spy ID"
+// @Success 200 {object} fiber.Map{token=string} "New token"
+// @Failure 403 {object} fiber.Map{error=string} "Unauthorized"
+// @Failure 404 {object} fiber.Map{error=string} "Spy Not Found"
+// @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
+// @Router /spy/{id}/token [post]
+func RotateSpyToken(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	spyId := c.Params("id")
+
+	token, err := services.RotateSpyToken(spyId, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"token": token,
+	})
+}
+
+// SignedPixelUrl godoc
+// @Summary Mint a signed pixel url
+// @Description Returns a pixel url of the spy carrying signed per-recipient fields (recipient, campaign,
+// @Description send timestamp and arbitrary key/values), only if the user is the owner
+// @Tags spies
+// @Accept json
+// @Produce json
+// @Param id path string true "Spy ID"
+// @Param SignedUrlRequest body requestmodels.SignedUrlRequest true "Recipient fields"
+// @Success 200 {object} fiber.Map{url=string} "Signed url"
+// @Failure 400 {object} fiber.Map{error=string} "Bad Request"
+// @Failure 403 {object} fiber.Map{error=string} "Unauthorized"
+// @Failure 404 {object} fiber.Map{error=string} "Spy Not Found"
+// @Failure 500 {object} fiber.Map{error=string} "Internal Server Error"
+// @Router /spy/{id}/url [post]
+func SignedPixelUrl(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	spyId := c.Params("id")
+
+	var req requestmodels.SignedUrlRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err := validation.SignedUrl(req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	url, err := services.SignedPixelUrl(spyId, req, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"url": url,
+	})
+}
+
 // DeleteSpy godoc
 // @Summary Delete a spy
 // @Description Deletes a spy specified by ID, only if the user is the owner
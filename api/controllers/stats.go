@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+	"github.com/ZiplEix/pixel-espion/services"
+	"github.com/ZiplEix/pixel-espion/validation"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetAllSpyStats godoc
+// @Summary Retrieve the stats of all the spies of the authenticated user
+// @Description Returns the total, human (not suspicious) and unique (first visits) record counts of each spy of
+// @Description the user over a period, the last 30 days by default
+// @Tags stats
+// @Produce json
+// @Param period query string false "Bucket precision of the bounds (hour, day), day by default"
+// @Param from query string false "Start of the period (RFC 3339)"
+// @Param to query string false "End of the period (RFC 3339), now by default"
+// @Param type query string false "Event type (open, prefetch, click, beacon, canary), open by default"
+// @Success 200 {object} fiber.Map{stats=[]services.SpyStats} "Counts per spy"
+// @Failure 400 {object} errorResponse "Bad Request"
+// @Failure 500 {object} errorResponse "Internal Server Error"
+// @Router /spy/stats [get]
+func GetAllSpyStats(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var req requestmodels.StatsRequest
+	if err := c.QueryParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	err := validation.Stats(req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	stats, err := services.GetAllSpyStats(req, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"stats": stats,
+	})
+}
+
+// GetSpyStats godoc
+// @Summary Retrieve the stats of a spy
+// @Description Returns the total, human (not suspicious) and unique (first visits) record counts of a spy per
+// @Description hour or day, only if the user is the owner. Buckets without records are left out.
+// @Tags stats
+// @Produce json
+// @Param id path string true "Spy ID"
+// @Param period query string false "Bucket size (hour, day), day by default"
+// @Param from query string false "Start of the period (RFC 3339), 30 days (or 2 days by hour) before its end by default"
+// @Param to query string false "End of the period (RFC 3339), now by default"
+// @Param type query string false "Event type (open, prefetch, click, beacon, canary), open by default"
+// @Success 200 {object} fiber.Map{buckets=[]models.Rollup} "Counts per bucket"
+// @Failure 400 {object} errorResponse "Bad Request"
+// @Failure 403 {object} errorResponse "Unauthorized"
+// @Failure 404 {object} errorResponse "Spy Not Found"
+// @Failure 500 {object} errorResponse "Internal Server Error"
+// @Router /spy/{id}/stats [get]
+func GetSpyStats(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	spyId := c.Params("id")
+
+	var req requestmodels.StatsRequest
+	if err := c.QueryParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	err := validation.Stats(req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	buckets, err := services.GetSpyStats(spyId, req, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"buckets": buckets,
+	})
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+	"github.com/ZiplEix/pixel-espion/services"
+	"github.com/ZiplEix/pixel-espion/validation"
+	"github.com/gofiber/fiber/v2"
+)
+
+// UpdateSettings godoc
+// @Summary Update the user settings
+// @Description Sets how the IP addresses of the new records are stored: in full, truncated to their network, hashed with a daily salt, or not at all. Spies may override it.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param settings body requestmodels.UpdateSettingsRequest true "User settings"
+// @Success 204 "No Content"
+// @Failure 400 {object} errorResponse "Bad Request"
+// @Failure 404 {object} errorResponse "User Not Found"
+// @Failure 500 {object} errorResponse "Internal Server Error"
+// @Router /user/settings [put]
+func UpdateSettings(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var req requestmodels.UpdateSettingsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	err := validation.UpdateSettings(req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	err = services.UpdateSettings(req, userId)
+	if err != nil {
+		return c.Status(err.(services.ServiceError).Code).JSON(errorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
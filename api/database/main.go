@@ -1,8 +1,10 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/ZiplEix/pixel-espion/models"
 	"gorm.io/driver/postgres"
@@ -33,13 +35,73 @@ func Connect() error {
 	return nil
 }
 
+// Ping checks that the database can be reached.
+func Ping() error {
+	sqlDb, err := Db.DB()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	return sqlDb.PingContext(ctx)
+}
+
 func Migrate() error {
 	fmt.Println("Migrating database...")
 
-	err := Db.AutoMigrate(&models.User{}, &models.Spy{}, &models.Record{})
+	// the rollups of the records stored before they existed are computed once
+	newRollups := !Db.Migrator().HasTable(&models.DailyRollup{})
+
+	err := Db.AutoMigrate(&models.User{}, &models.Spy{}, &models.Record{}, &models.Link{}, &models.IpSalt{}, &models.Recipient{}, &models.HourlyRollup{}, &models.DailyRollup{})
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	err = backfillSpyTokens()
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	err = partitionRecords()
 	if err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	// once more, for the indexes and constraints of the partitioned table
+	err = Db.AutoMigrate(&models.Record{})
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	if newRollups {
+		err = RebuildRollups()
+		if err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// backfillSpyTokens gives a token to the spies created before they existed.
+func backfillSpyTokens() error {
+	var spies []models.Spy
+	if err := Db.Unscoped().Where("token IS NULL OR token = ''").Find(&spies).Error; err != nil {
+		return err
+	}
+
+	for _, spy := range spies {
+		token, err := models.NewToken()
+		if err != nil {
+			return err
+		}
+
+		if err := Db.Unscoped().Model(&spy).Update("token", token).Error; err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,236 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// partitionsAhead is the number of months past the current one whose
+// partition of the records is created in advance.
+const partitionsAhead = 3
+
+// recordsDefaultPartition holds the records out of the range of the monthly
+// partitions, such as spooled records replayed after their partition was
+// dropped.
+const recordsDefaultPartition = "records_default"
+
+func partitionName(month time.Time) string {
+	return fmt.Sprintf("records_%04d_%02d", month.Year(), month.Month())
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// partitionRecords turns the records table into a table partitioned by month
+// on the record time, moving the existing records to their partition. It
+// does nothing once the table is partitioned.
+func partitionRecords() error {
+	var kind string
+	err := Db.Raw(`
+		SELECT c.relkind::text FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relname = 'records' AND n.nspname = current_schema()
+	`).Scan(&kind).Error
+	if err != nil {
+		return err
+	}
+	if kind != "r" {
+		// already partitioned
+		return nil
+	}
+
+	fmt.Println("Partitioning records...")
+
+	return Db.Transaction(func(tx *gorm.DB) error {
+		// the primary key must contain the partition key, and the id sequence
+		// must outlive the old table
+		statements := []string{
+			"LOCK TABLE records IN ACCESS EXCLUSIVE MODE",
+			"ALTER TABLE records RENAME TO records_unpartitioned",
+			"ALTER SEQUENCE records_id_seq OWNED BY NONE",
+			"CREATE TABLE records (LIKE records_unpartitioned INCLUDING DEFAULTS) PARTITION BY RANGE (time)",
+			"CREATE TABLE " + recordsDefaultPartition + " PARTITION OF records DEFAULT",
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		var oldest *time.Time
+		if err := tx.Raw("SELECT MIN(time) FROM records_unpartitioned").Scan(&oldest).Error; err != nil {
+			return err
+		}
+		from := time.Now()
+		if oldest != nil && oldest.Before(from) {
+			from = *oldest
+		}
+		if err := createPartitions(tx, from, time.Now()); err != nil {
+			return err
+		}
+
+		statements = []string{
+			"INSERT INTO records SELECT * FROM records_unpartitioned",
+			"DROP TABLE records_unpartitioned",
+			"ALTER SEQUENCE records_id_seq OWNED BY records.id",
+			"ALTER TABLE records ADD PRIMARY KEY (id, time)",
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// createPartitions creates the missing monthly partitions of the records,
+// from the month of from up to partitionsAhead months past the month of to.
+func createPartitions(tx *gorm.DB, from time.Time, to time.Time) error {
+	last := monthStart(to).AddDate(0, partitionsAhead, 0)
+	for month := monthStart(from); !month.After(last); month = month.AddDate(0, 1, 0) {
+		if err := createPartition(tx, month); err != nil {
+			return fmt.Errorf("failed to create partition %s: %w", partitionName(month), err)
+		}
+	}
+
+	return nil
+}
+
+// createPartition creates the partition of the records of the month, unless it
+// exists. A partition can't be created over records of the default partition,
+// such as spooled records replayed before it existed, so they are moved to it.
+func createPartition(tx *gorm.DB, month time.Time) error {
+	name := partitionName(month)
+	end := month.AddDate(0, 1, 0)
+
+	var exists bool
+	if err := tx.Raw("SELECT to_regclass(?) IS NOT NULL", name).Scan(&exists).Error; err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	return tx.Transaction(func(tx *gorm.DB) error {
+		statements := []struct {
+			sql  string
+			vars []any
+		}{
+			{"CREATE TEMP TABLE records_moved (LIKE " + recordsDefaultPartition + ")", nil},
+			{
+				"WITH moved AS (DELETE FROM " + recordsDefaultPartition + " WHERE time >= ? AND time < ? RETURNING *) INSERT INTO records_moved SELECT * FROM moved",
+				[]any{month, end},
+			},
+			{fmt.Sprintf(
+				"CREATE TABLE %s PARTITION OF records FOR VALUES FROM ('%s') TO ('%s')",
+				name, month.Format(time.RFC3339), end.Format(time.RFC3339),
+			), nil},
+			{"INSERT INTO records SELECT * FROM records_moved", nil},
+			{"DROP TABLE records_moved", nil},
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement.sql, statement.vars...).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// recordPartitions returns the months of the monthly partitions of the
+// records, oldest first.
+func recordPartitions() ([]time.Time, error) {
+	var names []string
+	err := Db.Raw(`
+		SELECT child.relname FROM pg_inherits
+		JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+		JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+		JOIN pg_namespace n ON n.oid = parent.relnamespace
+		WHERE parent.relname = 'records' AND n.nspname = current_schema()
+		ORDER BY child.relname
+	`).Scan(&names).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var months []time.Time
+	for _, name := range names {
+		month, err := time.Parse("2006_01", strings.TrimPrefix(name, "records_"))
+		if err != nil {
+			// the default partition
+			continue
+		}
+		months = append(months, month)
+	}
+
+	return months, nil
+}
+
+// retentionMonths returns the number of months of records kept, set by the
+// RECORDS_RETENTION_MONTHS env var. Records are kept forever when it is 0 or
+// unset.
+func retentionMonths() (int, error) {
+	value := os.Getenv("RECORDS_RETENTION_MONTHS")
+	if value == "" {
+		return 0, nil
+	}
+
+	months, err := strconv.Atoi(value)
+	if err != nil || months < 0 {
+		return 0, fmt.Errorf("invalid RECORDS_RETENTION_MONTHS '%s'", value)
+	}
+
+	return months, nil
+}
+
+// MaintainPartitions creates the partitions of the coming months, and drops
+// the ones past the retention along with the expired records of the default
+// partition. It returns the names of the partitions dropped. The rollups of
+// the dropped records are kept.
+func MaintainPartitions() ([]string, error) {
+	if err := createPartitions(Db, time.Now(), time.Now()); err != nil {
+		return nil, err
+	}
+
+	retention, err := retentionMonths()
+	if err != nil || retention == 0 {
+		return nil, err
+	}
+
+	// the current month counts as one
+	cutoff := monthStart(time.Now()).AddDate(0, 1-retention, 0)
+
+	months, err := recordPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	var dropped []string
+	for _, month := range months {
+		if !month.Before(cutoff) {
+			break
+		}
+
+		name := partitionName(month)
+		if err := Db.Exec("DROP TABLE " + name).Error; err != nil {
+			return dropped, fmt.Errorf("failed to drop partition %s: %w", name, err)
+		}
+		dropped = append(dropped, name)
+	}
+
+	err = Db.Exec("DELETE FROM "+recordsDefaultPartition+" WHERE time < ?", cutoff).Error
+	if err != nil {
+		return dropped, err
+	}
+
+	return dropped, nil
+}
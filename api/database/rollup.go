@@ -0,0 +1,56 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// rollupTables maps the rollup tables to the precision of their buckets.
+var rollupTables = map[string]string{
+	"hourly_rollups": "hour",
+	"daily_rollups":  "day",
+}
+
+// RebuildRollups recomputes the rollups from the records. The rollups older
+// than the oldest record are kept, as they may count records that are gone.
+func RebuildRollups() error {
+	return Db.Transaction(func(tx *gorm.DB) error {
+		// the records stored meanwhile wait for the rebuild to update their
+		// rollups, so that they are neither lost nor counted twice
+		if err := tx.Exec("LOCK TABLE hourly_rollups, daily_rollups IN EXCLUSIVE MODE").Error; err != nil {
+			return err
+		}
+
+		var oldest *time.Time
+		if err := tx.Raw("SELECT MIN(time) FROM records WHERE deleted_at IS NULL").Scan(&oldest).Error; err != nil {
+			return err
+		}
+		if oldest == nil {
+			return nil
+		}
+		since := oldest.UTC().Truncate(24 * time.Hour)
+
+		for table, precision := range rollupTables {
+			if err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE bucket >= ?", table), since).Error; err != nil {
+				return err
+			}
+
+			bucket := fmt.Sprintf("date_trunc('%s', time AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'", precision)
+			err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (spy_id, event_type, bucket, total, human, uniques)
+				SELECT spy_id, event_type, %s,
+					COUNT(*),
+					COUNT(*) FILTER (WHERE NOT suspicious),
+					COUNT(*) FILTER (WHERE NOT suspicious AND visit = 'first')
+				FROM records
+				WHERE deleted_at IS NULL AND time >= ?
+				GROUP BY 1, 2, 3`, table, bucket), since).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
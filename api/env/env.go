@@ -0,0 +1,42 @@
+// Package env reads the optional settings of the env vars, falling back to a
+// default when they are unset.
+package env
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Int returns the positive integer set in the env var, or the fallback when
+// it is unset.
+func Int(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s '%s'", name, value)
+	}
+
+	return n, nil
+}
+
+// Duration returns the positive duration set in the env var, such as "5m", or
+// the fallback when it is unset.
+func Duration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s '%s'", name, value)
+	}
+
+	return d, nil
+}
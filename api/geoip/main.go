@@ -0,0 +1,193 @@
+// Package geoip locates IP addresses using local MaxMind-format (.mmdb)
+// databases, such as GeoLite2-City and GeoLite2-ASN.
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// reloadInterval is how often the database files are checked for changes.
+const reloadInterval = time.Minute
+
+type Location struct {
+	Country   string
+	Region    string
+	City      string
+	Latitude  *float64
+	Longitude *float64
+	ASN       *uint
+	ASOrg     string
+}
+
+// entry holds the fields we read from the databases. City and ASN databases
+// each fill their own fields.
+type entry struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+type database struct {
+	path    string
+	modTime time.Time
+	reader  *maxminddb.Reader
+}
+
+var state struct {
+	sync.RWMutex
+	databases []*database
+}
+
+// Setup opens the databases listed in the comma separated GEOIP_DB env var.
+// Missing files are skipped: when none can be opened, lookups are disabled
+// but the service keeps working.
+func Setup() error {
+	fmt.Println("Setting up geoip...")
+
+	var databases []*database
+	for _, path := range strings.Split(os.Getenv("GEOIP_DB"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		db := &database{path: path}
+		if err := db.open(); err != nil {
+			fmt.Printf("GeoIP database '%s' not loaded: %v\n", path, err)
+		}
+		databases = append(databases, db)
+	}
+
+	state.Lock()
+	state.databases = databases
+	state.Unlock()
+
+	if len(databases) > 0 {
+		go watch()
+	}
+
+	return nil
+}
+
+func (db *database) open() error {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+
+	reader, err := maxminddb.Open(db.path)
+	if err != nil {
+		return err
+	}
+
+	if db.reader != nil {
+		db.reader.Close()
+	}
+	db.reader = reader
+	db.modTime = info.ModTime()
+
+	return nil
+}
+
+// watch reopens the databases whose file changed.
+func watch() {
+	for range time.Tick(reloadInterval) {
+		state.Lock()
+		for _, db := range state.databases {
+			info, err := os.Stat(db.path)
+			if err != nil || info.ModTime().Equal(db.modTime) {
+				continue
+			}
+
+			if err := db.open(); err != nil {
+				fmt.Printf("Failed to reload GeoIP database '%s': %v\n", db.path, err)
+				continue
+			}
+			fmt.Printf("GeoIP database '%s' reloaded\n", db.path)
+		}
+		state.Unlock()
+	}
+}
+
+// Enabled tells whether at least one database is loaded.
+func Enabled() bool {
+	state.RLock()
+	defer state.RUnlock()
+
+	for _, db := range state.databases {
+		if db.reader != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup locates an IP address in every loaded database. It returns false when
+// the address is invalid or no database knows about it.
+func Lookup(ip string) (Location, bool) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return Location{}, false
+	}
+
+	state.RLock()
+	defer state.RUnlock()
+
+	var loc Location
+	found := false
+	for _, db := range state.databases {
+		if db.reader == nil {
+			continue
+		}
+
+		var e entry
+		if _, ok, err := db.reader.LookupNetwork(addr, &e); err != nil || !ok {
+			continue
+		}
+		found = true
+
+		if e.Country.IsoCode != "" {
+			loc.Country = e.Country.IsoCode
+		}
+		if len(e.Subdivisions) > 0 {
+			loc.Region = e.Subdivisions[0].Names["en"]
+			if loc.Region == "" {
+				loc.Region = e.Subdivisions[0].IsoCode
+			}
+		}
+		if name := e.City.Names["en"]; name != "" {
+			loc.City = name
+		}
+		if e.Location.Latitude != nil && e.Location.Longitude != nil {
+			loc.Latitude = e.Location.Latitude
+			loc.Longitude = e.Location.Longitude
+		}
+		if e.ASN != 0 {
+			asn := e.ASN
+			loc.ASN = &asn
+			loc.ASOrg = e.ASOrg
+		}
+	}
+
+	return loc, found
+}
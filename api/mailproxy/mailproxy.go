@@ -0,0 +1,155 @@
+// Package mailproxy recognizes the requests made by the image proxies and
+// prefetchers of the mail providers (Gmail image proxy, Apple Mail Privacy
+// Protection, Outlook...), from their user agent and IP ranges.
+package mailproxy
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// Classes of requests.
+const (
+	Direct     = "direct"     // made by the reader's own client
+	Proxied    = "proxied"    // relayed by a proxy when the reader opened the mail
+	Prefetched = "prefetched" // made by a machine, whether the mail was opened or not
+)
+
+// proxy matches the requests whose user agent or IP address matches, or both
+// of them with MatchAll.
+type proxy struct {
+	Name      string   `json:"name"`
+	Class     string   `json:"class"`
+	UserAgent string   `json:"user_agent"`
+	Ranges    []string `json:"ranges"`
+	MatchAll  bool     `json:"match_all"`
+
+	userAgent *regexp.Regexp
+	networks  []*net.IPNet
+}
+
+type rules struct {
+	Proxies []*proxy `json:"proxies"`
+}
+
+//go:embed rules.json
+var defaultRules []byte
+
+var state struct {
+	sync.RWMutex
+	proxies []*proxy
+}
+
+func init() {
+	proxies, err := parse(defaultRules)
+	if err != nil {
+		panic(fmt.Errorf("invalid mail proxy rules: %w", err))
+	}
+	state.proxies = proxies
+}
+
+func parse(data []byte) ([]*proxy, error) {
+	var r rules
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+
+	for _, p := range r.Proxies {
+		if p.Class != Proxied && p.Class != Prefetched {
+			return nil, fmt.Errorf("invalid class '%s' of proxy '%s'", p.Class, p.Name)
+		}
+
+		if p.UserAgent != "" {
+			re, err := regexp.Compile(p.UserAgent)
+			if err != nil {
+				return nil, fmt.Errorf("invalid user agent of proxy '%s': %w", p.Name, err)
+			}
+			p.userAgent = re
+		}
+
+		for _, cidr := range p.Ranges {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range of proxy '%s': %w", p.Name, err)
+			}
+			p.networks = append(p.networks, network)
+		}
+
+		if p.MatchAll && (p.userAgent == nil || len(p.networks) == 0) {
+			return nil, fmt.Errorf("proxy '%s' must match both a user agent and ranges", p.Name)
+		}
+	}
+
+	return r.Proxies, nil
+}
+
+// Setup replaces the embedded rules by the ones of the file set in the
+// MAIL_PROXY_RULES env var, if any, so the list can be kept up to date
+// without a new build.
+func Setup() error {
+	path := os.Getenv("MAIL_PROXY_RULES")
+	if path == "" {
+		return nil
+	}
+
+	fmt.Println("Loading mail proxy rules...")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to load mail proxy rules: %w", err)
+	}
+
+	proxies, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("failed to load mail proxy rules: %w", err)
+	}
+
+	state.Lock()
+	state.proxies = proxies
+	state.Unlock()
+
+	return nil
+}
+
+// Classify tells whether a request comes from a known mail proxy, and which
+// one. The name is empty for direct requests.
+func Classify(ip string, userAgent string) (class string, name string) {
+	addr := net.ParseIP(ip)
+
+	state.RLock()
+	defer state.RUnlock()
+
+	for _, p := range state.proxies {
+		agentMatch := p.userAgent != nil && p.userAgent.MatchString(userAgent)
+		rangeMatch := p.contains(addr)
+
+		matched := agentMatch || rangeMatch
+		if p.MatchAll {
+			matched = agentMatch && rangeMatch
+		}
+		if matched {
+			return p.Class, p.Name
+		}
+	}
+
+	return Direct, ""
+}
+
+func (p *proxy) contains(addr net.IP) bool {
+	if addr == nil {
+		return false
+	}
+
+	for _, network := range p.networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+package mailproxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setRules loads the rules for the test, and the embedded ones back after it.
+func setRules(t *testing.T, data string) error {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := state.proxies
+	t.Cleanup(func() {
+		state.Lock()
+		state.proxies = previous
+		state.Unlock()
+	})
+
+	t.Setenv("MAIL_PROXY_RULES", path)
+	return Setup()
+}
+
+func TestClassify(t *testing.T) {
+	const browser = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36"
+
+	tests := []struct {
+		name      string
+		ip        string
+		userAgent string
+		wantClass string
+		wantName  string
+	}{
+		{"direct", "203.0.113.7", browser, Direct, ""},
+		{"gmail by user agent", "203.0.113.7", "Mozilla/5.0 (via ggpht.com GoogleImageProxy)", Proxied, "gmail"},
+		{"gmail by range", "66.249.84.1", browser, Proxied, "gmail"},
+		{"yahoo", "98.137.1.1", "YahooMailProxy; https://help.yahoo.com/kb/yahoo-mail-proxy-SLN28749.html", Proxied, "yahoo"},
+		{"outlook by range", "40.107.22.5", browser, Proxied, "outlook"},
+		{"outlook without its range", "203.0.113.7", "Microsoft Outlook 16.0", Direct, ""},
+		{"apple", "17.58.1.1", "Mozilla/5.0", Prefetched, "apple"},
+		{"apple range only", "17.58.1.1", browser, Direct, ""},
+		{"apple user agent only", "203.0.113.7", "Mozilla/5.0", Direct, ""},
+		{"invalid address", "unknown", browser, Direct, ""},
+		{"ipv6", "2001:db8::1", browser, Direct, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class, name := Classify(tt.ip, tt.userAgent)
+			if class != tt.wantClass || name != tt.wantName {
+				t.Errorf("Classify(%q, %q) = %q, %q, want %q, %q", tt.ip, tt.userAgent, class, name, tt.wantClass, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"valid", `{"proxies": [{"name": "corp", "class": "prefetched", "ranges": ["192.0.2.0/24"]}]}`, false},
+		{"invalid json", `{`, true},
+		{"invalid class", `{"proxies": [{"name": "corp", "class": "direct", "ranges": ["192.0.2.0/24"]}]}`, true},
+		{"invalid user agent", `{"proxies": [{"name": "corp", "class": "proxied", "user_agent": "("}]}`, true},
+		{"invalid range", `{"proxies": [{"name": "corp", "class": "proxied", "ranges": ["192.0.2.0/33"]}]}`, true},
+		{"match all without ranges", `{"proxies": [{"name": "corp", "class": "proxied", "user_agent": "corp", "match_all": true}]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := setRules(t, tt.data); (err != nil) != tt.wantErr {
+				t.Errorf("Setup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetupReplacesRules(t *testing.T) {
+	if err := setRules(t, `{"proxies": [{"name": "corp", "class": "prefetched", "ranges": ["192.0.2.0/24"]}]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if class, name := Classify("192.0.2.10", ""); class != Prefetched || name != "corp" {
+		t.Errorf("Classify() = %q, %q, want the loaded rule", class, name)
+	}
+	if class, _ := Classify("66.249.84.1", ""); class != Direct {
+		t.Errorf("Classify() = %q, want the embedded rules replaced", class)
+	}
+}
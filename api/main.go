@@ -5,9 +5,19 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/ZiplEix/pixel-espion/clientip"
 	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/geoip"
+	"github.com/ZiplEix/pixel-espion/mailproxy"
+	"github.com/ZiplEix/pixel-espion/rdns"
 	"github.com/ZiplEix/pixel-espion/routes"
+	"github.com/ZiplEix/pixel-espion/services"
+	"github.com/ZiplEix/pixel-espion/spool"
+	"github.com/ZiplEix/pixel-espion/storage"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -17,6 +27,10 @@ import (
 	_ "github.com/ZiplEix/pixel-espion/docs" // Swagger docs
 )
 
+// minPixelSecretSize is the shortest PIXEL_SECRET accepted, as it keys the
+// signatures of the pixel urls.
+const minPixelSecretSize = 32
+
 func checkEnv() error {
 	log.Printf("Checking environment variables...")
 
@@ -27,6 +41,13 @@ func checkEnv() error {
 	if _, ok := os.LookupEnv("VERSION"); !ok {
 		return errors.New("env var 'VERSION' is not set")
 	}
+	// tracking
+	if os.Getenv("TRACKING_ADDR") == "" && os.Getenv("TRACKING_PORT") == "" {
+		return errors.New("env var 'TRACKING_ADDR' or 'TRACKING_PORT' is not set")
+	}
+	if _, ok := os.LookupEnv("TRACKING_URL"); !ok {
+		return errors.New("env var 'TRACKING_URL' is not set")
+	}
 	// database
 	if _, ok := os.LookupEnv("POSTGRES_HOST"); !ok {
 		return errors.New("env var 'POSTGRES_HOST' is not set")
@@ -47,10 +68,26 @@ func checkEnv() error {
 	if _, ok := os.LookupEnv("JWT_SECRET"); !ok {
 		return errors.New("env var 'JWT_SECRET' is not set")
 	}
+	// pixel
+	if _, ok := os.LookupEnv("PIXEL_SECRET"); !ok {
+		return errors.New("env var 'PIXEL_SECRET' is not set")
+	}
+	if len(os.Getenv("PIXEL_SECRET")) < minPixelSecretSize {
+		return fmt.Errorf("env var 'PIXEL_SECRET' must be at least %d characters long", minPixelSecretSize)
+	}
 
 	return nil
 }
 
+// trackingAddr returns the address the tracking server listens on, every
+// interface on TRACKING_PORT unless TRACKING_ADDR is set.
+func trackingAddr() string {
+	if addr := os.Getenv("TRACKING_ADDR"); addr != "" {
+		return addr
+	}
+	return ":" + os.Getenv("TRACKING_PORT")
+}
+
 func init() {
 	err := godotenv.Load()
 	if err != nil {
@@ -62,6 +99,11 @@ func init() {
 		panic(err)
 	}
 
+	err = clientip.Setup()
+	if err != nil {
+		panic(err)
+	}
+
 	err = database.Connect()
 	if err != nil {
 		panic(err)
@@ -71,6 +113,48 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+
+	services.StartPartitionMaintenance()
+
+	err = storage.Setup()
+	if err != nil {
+		panic(err)
+	}
+
+	err = geoip.Setup()
+	if err != nil {
+		panic(err)
+	}
+
+	err = mailproxy.Setup()
+	if err != nil {
+		panic(err)
+	}
+
+	err = rdns.Setup()
+	if err != nil {
+		panic(err)
+	}
+
+	err = spool.Setup()
+	if err != nil {
+		panic(err)
+	}
+
+	err = services.StartSpoolReplay()
+	if err != nil {
+		panic(err)
+	}
+
+	err = services.SetupSpyCache()
+	if err != nil {
+		panic(err)
+	}
+
+	err = services.StartIngest()
+	if err != nil {
+		panic(err)
+	}
 }
 
 // @title pixe espion API
@@ -100,6 +184,52 @@ func main() {
 
 	app.Get("/swagger/*", swagger.HandlerDefault)
 
-	fmt.Println("Server is running on http://localhost:" + os.Getenv("PORT"))
-	log.Fatal(app.Listen(":" + os.Getenv("PORT")))
+	// the public tracking endpoints (pixels, links, beacons) get their own
+	// listener, without CORS nor swagger, so they can be exposed and scaled
+	// separately from the API
+	tracking := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+		BodyLimit:             64 * 1024,
+		// the records outlive the requests, in the ingestion queue, and the
+		// tokens key the spy cache, so the strings fiber returns must not be
+		// views of its reused buffers
+		Immutable: true,
+	})
+
+	tracking.Use(logger.New(logger.Config{
+		Format: "${time} ${status} ${method} ${path}\n",
+	}))
+
+	routes.SetupTrackingRoutes(tracking)
+
+	errs := make(chan error, 2)
+	go func() {
+		fmt.Println("Server is running on http://localhost:" + os.Getenv("PORT"))
+		errs <- app.Listen(":" + os.Getenv("PORT"))
+	}()
+	go func() {
+		fmt.Println("Tracking server is running on " + trackingAddr())
+		errs <- tracking.Listen(trackingAddr())
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	// when one of the servers stops, the other one is stopped as well
+	select {
+	case err := <-errs:
+		log.Printf("Server stopped: %v", err)
+	case <-quit:
+	}
+
+	fmt.Println("Shutting down...")
+	if err := app.ShutdownWithTimeout(10 * time.Second); err != nil {
+		log.Printf("Failed to shut down server: %v", err)
+	}
+	if err := tracking.ShutdownWithTimeout(10 * time.Second); err != nil {
+		log.Printf("Failed to shut down tracking server: %v", err)
+	}
+
+	// the tracking server is down, no record can be added anymore
+	services.StopIngest()
 }
@@ -0,0 +1,26 @@
+package models
+
+import "gorm.io/gorm"
+
+type Link struct {
+	gorm.Model
+	Name  string `gorm:"not null"`
+	Url   string `gorm:"not null"`
+	Token string `gorm:"uniqueIndex;size:32"`
+	SpyID uint   `gorm:"not null;index"`
+	Spy   Spy    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
+
+func (l *Link) BeforeCreate(tx *gorm.DB) error {
+	if l.Token != "" {
+		return nil
+	}
+
+	token, err := NewToken()
+	if err != nil {
+		return err
+	}
+	l.Token = token
+
+	return nil
+}
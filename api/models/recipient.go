@@ -0,0 +1,29 @@
+package models
+
+import "gorm.io/gorm"
+
+// Recipient is a person a spy is sent to. Each recipient gets its own pixel
+// url, carrying its token, so that the records resolve to it.
+type Recipient struct {
+	gorm.Model
+	Email  string            `gorm:"not null;uniqueIndex:idx_recipients_spy_email,priority:2"`
+	Name   string            // display name
+	Fields map[string]string `gorm:"serializer:json"` // custom fields
+	Token  string            `gorm:"uniqueIndex;size:32"`
+	SpyID  uint              `gorm:"not null;uniqueIndex:idx_recipients_spy_email,priority:1"`
+	Spy    Spy               `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+func (r *Recipient) BeforeCreate(tx *gorm.DB) error {
+	if r.Token != "" {
+		return nil
+	}
+
+	token, err := NewToken()
+	if err != nil {
+		return err
+	}
+	r.Token = token
+
+	return nil
+}
@@ -6,10 +6,80 @@ import (
 	"gorm.io/gorm"
 )
 
+// Kind of event a record was created for.
+const (
+	EventOpen     = "open"     // the pixel of a spy was loaded
+	EventPrefetch = "prefetch" // the pixel was checked (HEAD or conditional request) rather than loaded
+	EventClick    = "click"    // a tracked link was followed
+	EventBeacon   = "beacon"   // a web page sent a beacon
+	EventCanary   = "canary"   // a canary token was triggered
+)
+
+// How a record relates to the previous ones of the same visitor.
+const (
+	VisitFirst  = "first"  // first record of the visitor
+	VisitRepeat = "repeat" // within the dedup window of the previous record
+	VisitReopen = "reopen" // after the dedup window
+)
+
+// How the IP addresses of a record are stored.
+const (
+	IpFull      = "full"      // as is
+	IpTruncated = "truncated" // reduced to their /24 (IPv4) or /48 (IPv6) network
+	IpHashed    = "hashed"    // keyed hash, with a salt rotating daily
+	IpNone      = "none"      // not stored
+)
+
+// Status of the signature of the per-recipient fields of a record.
+const (
+	SignatureNone    = "none"    // the pixel url carried no signed fields
+	SignatureValid   = "valid"   // the fields were signed by us
+	SignatureInvalid = "invalid" // the fields were unsigned or tampered with
+)
+
 type Record struct {
 	gorm.Model
-	Ip    string    `gorm:"not null"`
-	Time  time.Time `gorm:"not null"`
-	SpyID uint      `gorm:"not null"`                                       // Ajout de la clé étrangère vers Spy
-	Spy   Spy       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"` // Relation avec Spy
+	Ip              string         `gorm:"not null"`
+	IpMode          string         `gorm:"not null;default:full"`                             // anonymization applied to Ip and ForwardedChain
+	ForwardedChain  []string       `gorm:"serializer:json"`                                   // forwarding chain announced by the proxies, kept for auditing
+	Time            time.Time      `gorm:"not null;uniqueIndex:idx_records_spool,priority:2"` // the table is partitioned by month on it
+	EventType       string         `gorm:"not null;default:open;index"`
+	Payload         map[string]any `gorm:"serializer:json;type:jsonb"`
+	UserAgent       *string        // request details, null on the records created before they were captured
+	Referer         *string
+	AcceptLanguage  *string
+	Host            *string
+	Headers         map[string]string `gorm:"serializer:json"`
+	Browser         string            `gorm:"index"` // parsed from the user agent
+	BrowserVersion  string
+	OS              string `gorm:"index"`
+	OSVersion       string
+	Device          string `gorm:"index"`
+	EmailClient     string `gorm:"index"`
+	Country         string `gorm:"index"` // located from the IP address
+	Region          string
+	City            string
+	Latitude        *float64
+	Longitude       *float64
+	ASN             *uint `gorm:"index"`
+	ASOrg           string
+	Hostname        string // reverse DNS of the IP address, resolved after the record is stored
+	ProxyClass      string `gorm:"index"` // direct, proxied or prefetched, see package mailproxy
+	ProxyName       string
+	BotScore        int // see package botdetect
+	BotReason       string
+	Suspicious      bool   `gorm:"not null;default:false;index"` // bot score above the threshold of the spy
+	Recipient       string `gorm:"index"`
+	RecipientID     *uint  `gorm:"index"`                                        // attached recipient the pixel url was minted for
+	RcptToken       string `gorm:"-" json:"-"`                                   // token of the attached recipient in the pixel url, until it is resolved
+	VisitorKey      string `gorm:"index:idx_records_visitor,priority:2;size:32"` // keyed hash of the IP, user agent and recipient
+	Visit           string `gorm:"index"`                                        // first, repeat or reopen
+	Campaign        string `gorm:"index"`
+	SentAt          *time.Time
+	Params          map[string]string `gorm:"serializer:json"`
+	SignatureStatus string            `gorm:"not null;default:none"`
+	SpyID           uint              `gorm:"not null;index:idx_records_visitor,priority:1"`  // Ajout de la clé étrangère vers Spy
+	Spy             Spy               `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"` // Relation avec Spy
+	LinkID          *uint             `gorm:"index"`
+	SpoolID         *string           `gorm:"uniqueIndex:idx_records_spool,priority:1;size:32"` // id of the spool entry the record was replayed from
 }
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Rollup counts the records of a spy and event type within a time bucket, so
+// that stats don't scan the records table.
+type Rollup struct {
+	SpyID     uint      `gorm:"primaryKey"`
+	EventType string    `gorm:"primaryKey"`
+	Bucket    time.Time `gorm:"primaryKey"` // start of the hour or day, in UTC
+	Total     int64     `gorm:"not null;default:0"`
+	Human     int64     `gorm:"not null;default:0"`                // records that are not suspicious
+	Unique    int64     `gorm:"column:uniques;not null;default:0"` // human records of new visitors
+}
+
+type HourlyRollup struct {
+	Rollup
+}
+
+type DailyRollup struct {
+	Rollup
+}
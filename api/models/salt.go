@@ -0,0 +1,8 @@
+package models
+
+// IpSalt is the random salt of the IP addresses hashed on a given day. Salts
+// of the past days are deleted, so their hashes can't be reversed anymore.
+type IpSalt struct {
+	Day  string `gorm:"primaryKey;size:10"` // YYYY-MM-DD
+	Salt string `gorm:"not null"`
+}
@@ -4,8 +4,39 @@ import "gorm.io/gorm"
 
 type Spy struct {
 	gorm.Model
-	Name   string `gorm:"not null"`
-	Color  string `gorm:"not null"`
-	UserId uint   `gorm:"not null"`
-	User   User   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	Name      string `gorm:"not null"`
+	Token     string `gorm:"uniqueIndex;size:32"`
+	Color     string `gorm:"not null"`
+	Width     uint   `gorm:"not null;default:1"`
+	Height    uint   `gorm:"not null;default:1"`
+	Alpha     uint8  `gorm:"not null;default:255"`
+	Image     string // storage key of the uploaded image, if any
+	ImageType string
+	// hits with a bot score above the threshold are flagged as suspicious and
+	// left out of the counts
+	BotThreshold int    `gorm:"not null;default:50"`
+	IpMode       string // anonymization of the IP addresses, the one of the user when empty
+	// minutes during which the hits of a visitor are repeats of the previous one
+	DedupWindow uint `gorm:"not null;default:30"`
+	// counts of the opens, filled by the queries that select them. Opens and
+	// UniqueOpens leave the suspicious ones out, TotalOpens counts them all.
+	TotalOpens  int64 `gorm:"->;-:migration"`
+	Opens       int64 `gorm:"->;-:migration"`
+	UniqueOpens int64 `gorm:"->;-:migration"`
+	UserId      uint  `gorm:"not null"`
+	User        User  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
+
+func (s *Spy) BeforeCreate(tx *gorm.DB) error {
+	if s.Token != "" {
+		return nil
+	}
+
+	token, err := NewToken()
+	if err != nil {
+		return err
+	}
+	s.Token = token
+
+	return nil
 }
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// NewToken returns a random, url safe token used to identify a resource in
+// public urls without exposing its database ID.
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -7,4 +7,5 @@ type User struct {
 	Email    string `gorm:"uniqueIndex;not null;type:varchar(100)"`
 	Name     string `gorm:"not null"`
 	Password string `gorm:"not null"`
+	IpMode   string `gorm:"not null;default:full"` // anonymization of the IP addresses of the records
 }
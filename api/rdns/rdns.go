@@ -0,0 +1,178 @@
+// Package rdns resolves the hostname of IP addresses (PTR records) in the
+// background, so that the requests being tracked never wait on DNS.
+package rdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/env"
+)
+
+// Defaults of the RDNS_* env vars.
+const (
+	defaultTimeout  = 2 * time.Second
+	defaultCacheTTL = time.Hour
+	defaultQueue    = 1024
+	defaultWorkers  = 4
+)
+
+// maxCacheSize bounds the number of addresses kept in the cache.
+const maxCacheSize = 10000
+
+type job struct {
+	ip   string
+	done func(hostname string)
+}
+
+type cacheEntry struct {
+	hostname string
+	expires  time.Time
+}
+
+var state struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+	ttl      time.Duration
+	queue    chan job
+
+	sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// Setup starts the workers, configured by the env vars:
+//   - RDNS_RESOLVER: address (host:port) of the DNS server to query, the one of
+//     the system when empty, or "off" to disable the lookups
+//   - RDNS_TIMEOUT: timeout of a lookup, such as "2s"
+//   - RDNS_CACHE_TTL: how long a hostname is remembered, such as "1h"
+//   - RDNS_QUEUE: how many lookups may wait, the others are dropped
+//   - RDNS_WORKERS: how many lookups run at once
+func Setup() error {
+	fmt.Println("Setting up reverse DNS...")
+
+	address := strings.TrimSpace(os.Getenv("RDNS_RESOLVER"))
+	if address == "off" {
+		fmt.Println("Reverse DNS lookups disabled")
+		return nil
+	}
+
+	timeout, err := env.Duration("RDNS_TIMEOUT", defaultTimeout)
+	if err != nil {
+		return err
+	}
+	ttl, err := env.Duration("RDNS_CACHE_TTL", defaultCacheTTL)
+	if err != nil {
+		return err
+	}
+	size, err := env.Int("RDNS_QUEUE", defaultQueue)
+	if err != nil {
+		return err
+	}
+	workers, err := env.Int("RDNS_WORKERS", defaultWorkers)
+	if err != nil {
+		return err
+	}
+
+	resolver := net.DefaultResolver
+	if address != "" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("invalid RDNS_RESOLVER '%s': %w", address, err)
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			},
+		}
+	}
+
+	state.resolver = resolver
+	state.timeout = timeout
+	state.ttl = ttl
+	state.cache = make(map[string]cacheEntry)
+	state.queue = make(chan job, size)
+
+	for i := 0; i < workers; i++ {
+		go work(state.queue)
+	}
+
+	return nil
+}
+
+// Enqueue schedules the lookup of the hostname of the IP address, done being
+// called with it once resolved. It never blocks: the lookup is dropped, and
+// false returned, when the queue is full or the lookups are disabled.
+func Enqueue(ip string, done func(hostname string)) bool {
+	if state.queue == nil || net.ParseIP(ip) == nil {
+		return false
+	}
+
+	select {
+	case state.queue <- job{ip: ip, done: done}:
+		return true
+	default:
+		return false
+	}
+}
+
+func work(queue chan job) {
+	for j := range queue {
+		hostname, ok := Lookup(j.ip)
+		if ok && hostname != "" {
+			j.done(hostname)
+		}
+	}
+}
+
+// Lookup returns the hostname of the IP address, empty when it has none. ok is
+// false when the lookup failed for another reason, such as a timeout.
+func Lookup(ip string) (hostname string, ok bool) {
+	state.Lock()
+	entry, found := state.cache[ip]
+	state.Unlock()
+	if found && time.Now().Before(entry.expires) {
+		return entry.hostname, true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), state.timeout)
+	defer cancel()
+
+	names, err := state.resolver.LookupAddr(ctx, ip)
+	if err != nil {
+		if dnsErr, isDns := err.(*net.DNSError); !isDns || !dnsErr.IsNotFound {
+			return "", false
+		}
+	}
+	if len(names) > 0 {
+		hostname = strings.TrimSuffix(names[0], ".")
+	}
+
+	state.Lock()
+	if len(state.cache) >= maxCacheSize {
+		evict()
+	}
+	state.cache[ip] = cacheEntry{hostname: hostname, expires: time.Now().Add(state.ttl)}
+	state.Unlock()
+
+	return hostname, true
+}
+
+// evict drops the expired entries of the cache, or all of them if none is.
+// The lock must be held.
+func evict() {
+	now := time.Now()
+	for ip, entry := range state.cache {
+		if now.After(entry.expires) {
+			delete(state.cache, ip)
+		}
+	}
+	if len(state.cache) >= maxCacheSize {
+		state.cache = make(map[string]cacheEntry)
+	}
+}
@@ -0,0 +1,243 @@
+package rdns
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	resolvedIp = "203.0.113.7"
+	missingIp  = "203.0.113.8"
+	silentIp   = "203.0.113.9"
+	hostname   = "mail.example.com"
+)
+
+// stub is a DNS server answering the PTR queries of resolvedIp, with NXDOMAIN
+// for missingIp, and never for silentIp.
+type stub struct {
+	conn net.PacketConn
+
+	sync.Mutex
+	queries map[string]int
+}
+
+func startStub(t *testing.T) *stub {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stub{conn: conn, queries: make(map[string]int)}
+	t.Cleanup(func() { conn.Close() })
+
+	go s.serve()
+	return s
+}
+
+func (s *stub) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if reply := s.answer(buf[:n]); reply != nil {
+			s.conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+// answer returns the reply to a query, nil to leave it unanswered.
+func (s *stub) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+
+	// the question is the name, as length prefixed labels, then its type and
+	// class
+	var labels []string
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		size := int(query[end])
+		if end+1+size > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[end+1:end+1+size]))
+		end += 1 + size
+	}
+	end += 5
+	if end > len(query) {
+		return nil
+	}
+	name := strings.Join(labels, ".")
+
+	s.Lock()
+	s.queries[name]++
+	s.Unlock()
+
+	reply := make([]byte, 12, 512)
+	copy(reply, query[:2])
+	binary.BigEndian.PutUint16(reply[4:], 1)
+	reply = append(reply, query[12:end]...)
+
+	switch name {
+	case arpa(resolvedIp):
+		binary.BigEndian.PutUint16(reply[2:], 0x8180)
+		binary.BigEndian.PutUint16(reply[6:], 1)
+
+		var rdata []byte
+		for _, label := range strings.Split(hostname, ".") {
+			rdata = append(rdata, byte(len(label)))
+			rdata = append(rdata, label...)
+		}
+		rdata = append(rdata, 0)
+
+		// pointer to the name of the question, type PTR, class IN and TTL
+		reply = append(reply, 0xc0, 12, 0, 12, 0, 1, 0, 0, 0x0e, 0x10)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(len(rdata)))
+		reply = append(reply, rdata...)
+	case arpa(missingIp):
+		binary.BigEndian.PutUint16(reply[2:], 0x8183)
+	default:
+		return nil
+	}
+
+	return reply
+}
+
+func (s *stub) count(ip string) int {
+	s.Lock()
+	defer s.Unlock()
+	return s.queries[arpa(ip)]
+}
+
+// arpa returns the name of the PTR record of an IPv4 address.
+func arpa(ip string) string {
+	parts := strings.Split(ip, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, ".") + ".in-addr.arpa"
+}
+
+// setup sets the package up against the stub, with a short timeout.
+func setup(t *testing.T, s *stub) {
+	t.Helper()
+
+	t.Setenv("RDNS_RESOLVER", s.conn.LocalAddr().String())
+	t.Setenv("RDNS_TIMEOUT", "200ms")
+	t.Setenv("RDNS_CACHE_TTL", "")
+	t.Setenv("RDNS_QUEUE", "")
+	t.Setenv("RDNS_WORKERS", "1")
+	if err := Setup(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name         string
+		ip           string
+		wantHostname string
+		wantOk       bool
+	}{
+		{"resolved", resolvedIp, hostname, true},
+		{"nxdomain", missingIp, "", true},
+		{"timeout", silentIp, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := startStub(t)
+			setup(t, s)
+
+			start := time.Now()
+			got, ok := Lookup(tt.ip)
+			if got != tt.wantHostname || ok != tt.wantOk {
+				t.Errorf("Lookup(%q) = %q, %v, want %q, %v", tt.ip, got, ok, tt.wantHostname, tt.wantOk)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Lookup(%q) took %s, past the timeout", tt.ip, elapsed)
+			}
+		})
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	s := startStub(t)
+	setup(t, s)
+
+	for _, ip := range []string{resolvedIp, missingIp} {
+		for i := 0; i < 3; i++ {
+			Lookup(ip)
+		}
+		if n := s.count(ip); n != 1 {
+			t.Errorf("%d queries for %q, want the answer cached after the first one", n, ip)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		Lookup(silentIp)
+	}
+	if n := s.count(silentIp); n < 2 {
+		t.Errorf("%d queries for %q, want the failures left out of the cache", n, silentIp)
+	}
+
+	state.Lock()
+	state.cache[resolvedIp] = cacheEntry{hostname: hostname, expires: time.Now().Add(-time.Second)}
+	state.Unlock()
+	if got, ok := Lookup(resolvedIp); got != hostname || !ok {
+		t.Errorf("Lookup() = %q, %v after expiry, want %q, true", got, ok, hostname)
+	}
+	if n := s.count(resolvedIp); n != 2 {
+		t.Errorf("%d queries for %q, want it queried again after expiry", n, resolvedIp)
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	s := startStub(t)
+	setup(t, s)
+
+	done := make(chan string, 1)
+	if !Enqueue(resolvedIp, func(hostname string) { done <- hostname }) {
+		t.Fatal("Enqueue() = false with an empty queue")
+	}
+
+	select {
+	case got := <-done:
+		if got != hostname {
+			t.Errorf("done called with %q, want %q", got, hostname)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("done not called")
+	}
+}
+
+func TestEnqueueDrops(t *testing.T) {
+	previous := state.queue
+	t.Cleanup(func() { state.queue = previous })
+
+	// a queue without workers, so that it stays full
+	state.queue = make(chan job, 1)
+	noop := func(string) {}
+
+	if !Enqueue(resolvedIp, noop) {
+		t.Fatal("Enqueue() = false with an empty queue")
+	}
+	if Enqueue(resolvedIp, noop) {
+		t.Error("Enqueue() = true with a full queue")
+	}
+	if Enqueue("unknown", noop) {
+		t.Error("Enqueue() = true for an invalid address")
+	}
+
+	state.queue = nil
+	if Enqueue(resolvedIp, noop) {
+		t.Error("Enqueue() = true with the lookups disabled")
+	}
+}
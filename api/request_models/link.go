@@ -0,0 +1,12 @@
+package requestmodels
+
+type NewLinkRequest struct {
+	SpyId uint   `json:"spy_id" validate:"required"`
+	Name  string `json:"name" validate:"required,min=3,max=50"`
+	Url   string `json:"url" validate:"required,http_url,max=2048"`
+}
+
+type UpdateLinkRequest struct {
+	Name string `json:"name" validate:"required,min=3,max=50"`
+	Url  string `json:"url" validate:"required,http_url,max=2048"`
+}
@@ -0,0 +1,20 @@
+package requestmodels
+
+type RecipientRequest struct {
+	Email  string            `json:"email" validate:"required,email,max=255"`
+	Name   string            `json:"name" validate:"max=255"`
+	Fields map[string]string `json:"fields" validate:"max=20,dive,keys,min=1,max=50,endkeys,max=255"`
+}
+
+type AttachRecipientsRequest struct {
+	Recipients []RecipientRequest `json:"recipients" validate:"required,min=1,max=1000,dive"`
+}
+
+type RecipientFilter struct {
+	Opened *bool `query:"opened"`
+}
+
+type RecipientUrlsRequest struct {
+	Campaign string `query:"campaign" validate:"max=255"`
+	Format   string `query:"format" validate:"omitempty,oneof=png gif webp svg"`
+}
@@ -0,0 +1,14 @@
+package requestmodels
+
+type RecordFilter struct {
+	Type              string `query:"type" validate:"omitempty,oneof=open prefetch click beacon canary"`
+	Browser           string `query:"browser" validate:"max=50"`
+	OS                string `query:"os" validate:"max=50"`
+	Device            string `query:"device" validate:"omitempty,oneof=desktop mobile tablet bot"`
+	EmailClient       string `query:"email_client" validate:"max=50"`
+	Proxy             string `query:"proxy" validate:"omitempty,oneof=direct proxied prefetched"`
+	ExcludeProxied    bool   `query:"exclude_proxied"`
+	ExcludeSuspicious bool   `query:"exclude_suspicious"`
+	Visit             string `query:"visit" validate:"omitempty,oneof=first repeat reopen"`
+	GroupBy           string `query:"group_by" validate:"omitempty,oneof=browser os device email_client proxy"`
+}
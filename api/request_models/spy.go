@@ -1,12 +1,43 @@
 package requestmodels
 
-import "github.com/ZiplEix/pixel-espion/models"
+import (
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/models"
+)
 
 type NewSpyRequest struct {
-	Name  string `json:"name" validate:"required,min=3,max=50"`
-	Color string `json:"color" validate:"required,hexcolor"`
+	Name         string `json:"name" validate:"required,min=3,max=50"`
+	Color        string `json:"color" validate:"required,hexcolor"`
+	Width        uint   `json:"width" validate:"omitempty,min=1,max=100"`
+	Height       uint   `json:"height" validate:"omitempty,min=1,max=100"`
+	Alpha        *uint8 `json:"alpha"`
+	BotThreshold *int   `json:"bot_threshold" validate:"omitempty,min=1,max=100"`
+	IpMode       string `json:"ip_mode" validate:"omitempty,oneof=full truncated hashed none"`
+	DedupWindow  uint   `json:"dedup_window" validate:"omitempty,min=1,max=10080"` // minutes
+}
+
+// UpdateSpyRequest changes the fields it sets, the others keeping their value.
+// An empty ip_mode brings the spy back to the IP mode of its owner.
+type UpdateSpyRequest struct {
+	Name         *string `json:"name" validate:"omitempty,min=3,max=50"`
+	Color        *string `json:"color" validate:"omitempty,hexcolor"`
+	Width        *uint   `json:"width" validate:"omitempty,min=1,max=100"`
+	Height       *uint   `json:"height" validate:"omitempty,min=1,max=100"`
+	Alpha        *uint8  `json:"alpha"`
+	BotThreshold *int    `json:"bot_threshold" validate:"omitempty,min=1,max=100"`
+	IpMode       *string `json:"ip_mode" validate:"omitempty,oneof='' full truncated hashed none"`
+	DedupWindow  *uint   `json:"dedup_window" validate:"omitempty,min=1,max=10080"` // minutes
 }
 
 type GetAllSpiesResponse struct {
 	Spies []models.Spy `json:"spies"`
 }
+
+type SignedUrlRequest struct {
+	Recipient string            `json:"recipient" validate:"max=255"`
+	Campaign  string            `json:"campaign" validate:"max=255"`
+	SentAt    *time.Time        `json:"sent_at"`
+	Params    map[string]string `json:"params" validate:"max=20,dive,keys,min=1,max=50,endkeys,max=255"`
+	Format    string            `json:"format" validate:"omitempty,oneof=png gif webp svg"`
+}
@@ -0,0 +1,8 @@
+package requestmodels
+
+type StatsRequest struct {
+	Period string `query:"period" validate:"omitempty,oneof=hour day"`
+	From   string `query:"from" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	To     string `query:"to" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	Type   string `query:"type" validate:"omitempty,oneof=open prefetch click beacon canary"`
+}
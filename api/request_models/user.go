@@ -0,0 +1,5 @@
+package requestmodels
+
+type UpdateSettingsRequest struct {
+	IpMode string `json:"ip_mode" validate:"required,oneof=full truncated hashed none"`
+}
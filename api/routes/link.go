@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/ZiplEix/pixel-espion/controllers"
+	"github.com/ZiplEix/pixel-espion/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func linkRoutes(app *fiber.App) {
+	linkGroup := app.Group("/link", middlewares.Protected)
+	linkGroup.Post("/new", controllers.NewLink)
+	linkGroup.Get("/all", controllers.GetAllLinks)
+	linkGroup.Get("/:id", controllers.GetLink)
+	linkGroup.Put("/:id", controllers.UpdateLink)
+	linkGroup.Delete("/:id", controllers.DeleteLink)
+}
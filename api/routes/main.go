@@ -5,5 +5,11 @@ import "github.com/gofiber/fiber/v2"
 func SetupRoutes(app *fiber.App) {
 	version(app)
 	spyRoutes(app)
+	linkRoutes(app)
 	authRoutes(app)
+	userRoutes(app)
+}
+
+func SetupTrackingRoutes(app *fiber.App) {
+	trackingRoutes(app)
 }
@@ -7,14 +7,22 @@ import (
 )
 
 func spyRoutes(app *fiber.App) {
-	app.Get("/spy/pixel1", controllers.Pixel1)
-
 	spyGroup := app.Group("/spy", middlewares.Protected)
 	spyGroup.Post("/new", controllers.NewSpy)
 	spyGroup.Get("/all", controllers.GetAllSpies)
+	spyGroup.Get("/stats", controllers.GetAllSpyStats)
 	spyGroup.Get("/:id", controllers.GetSpy)
 	spyGroup.Put("/:id", controllers.UpdateSpy)
 	spyGroup.Delete("/:id", controllers.DeleteSpy)
+	spyGroup.Post("/:id/token", controllers.RotateSpyToken)
+	spyGroup.Post("/:id/url", controllers.SignedPixelUrl)
+	spyGroup.Post("/:id/image", controllers.UploadSpyImage)
+	spyGroup.Delete("/:id/image", controllers.DeleteSpyImage)
+	spyGroup.Post("/:id/recipients", controllers.AttachRecipients)
+	spyGroup.Get("/:id/recipients", controllers.GetSpyRecipients)
+	spyGroup.Get("/:id/recipients/urls", controllers.RecipientUrls)
+	spyGroup.Delete("/:id/recipients/:recipientId", controllers.DeleteRecipient)
+	spyGroup.Get("/:id/stats", controllers.GetSpyStats)
 
 	recordGroup := app.Group("/record", middlewares.Protected)
 	recordGroup.Get("/all", controllers.GetAllRecords)
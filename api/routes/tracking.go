@@ -0,0 +1,40 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/clientip"
+	"github.com/ZiplEix/pixel-espion/controllers"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+func trackingRoutes(app *fiber.App) {
+	// pixels
+	app.Get("/spy/pixel1", controllers.Pixel1)
+	app.Get("/p/:token.:ext", controllers.Pixel)
+	app.Get("/p/:token", controllers.Pixel)
+
+	// links
+	app.Get("/l/:token", controllers.Click)
+
+	// beacons
+	throttle := limiter.New(limiter.Config{
+		Max:        60,
+		Expiration: time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			ip, _ := clientip.Resolve(c.IP(), func(header string) string {
+				return c.Get(header)
+			})
+			return ip
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many beacons, slow down",
+			})
+		},
+	})
+
+	app.Get("/b/:token.js", controllers.BeaconScript)
+	app.Post("/b/:token", throttle, controllers.Beacon)
+}
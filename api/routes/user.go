@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/ZiplEix/pixel-espion/controllers"
+	"github.com/ZiplEix/pixel-espion/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func userRoutes(app *fiber.App) {
+	userGroup := app.Group("/user", middlewares.Protected)
+	userGroup.Put("/settings", controllers.UpdateSettings)
+}
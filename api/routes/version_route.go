@@ -3,6 +3,8 @@ package routes
 import (
 	"os"
 
+	"github.com/ZiplEix/pixel-espion/middlewares"
+	"github.com/ZiplEix/pixel-espion/services"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -52,7 +54,45 @@ func versionInfos(c *fiber.Ctx) error {
 	return c.JSON(res)
 }
 
+type ingestResponse struct {
+	Queued   int `json:"queued"`
+	Capacity int `json:"capacity"`
+}
+
+// @summary Get the ingestion queue depth
+// @description Get how many records are waiting to be stored, and how many may wait at most
+// @tags version
+// @accept */*
+// @produce application/json
+// @success 200 {object} ingestResponse
+// @failure 401 {object} fiber.Map{error=string} "Unauthorized"
+// @router /ingest [get]
+func ingestInfos(c *fiber.Ctx) error {
+	queued, capacity := services.IngestQueue()
+
+	res := ingestResponse{
+		Queued:   queued,
+		Capacity: capacity,
+	}
+
+	return c.JSON(res)
+}
+
+// @summary Get the spy cache counters
+// @description Get the number of spies cached and the hits and misses of the cache since the start
+// @tags version
+// @accept */*
+// @produce application/json
+// @success 200 {object} services.SpyCacheStats
+// @failure 401 {object} fiber.Map{error=string} "Unauthorized"
+// @router /cache [get]
+func cacheInfos(c *fiber.Ctx) error {
+	return c.JSON(services.GetSpyCacheStats())
+}
+
 func version(app *fiber.App) {
 	app.Get("/", route)
 	app.Get("/version", versionInfos)
+	app.Get("/ingest", middlewares.Protected, ingestInfos)
+	app.Get("/cache", middlewares.Protected, cacheInfos)
 }
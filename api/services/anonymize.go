@@ -0,0 +1,95 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/models"
+	"gorm.io/gorm/clause"
+)
+
+var ipSalt struct {
+	sync.Mutex
+	day  string
+	salt []byte
+}
+
+// dailySalt returns the salt of the current day, creating it if needed. The
+// salt is shared through the database so that every instance hashes alike.
+func dailySalt() ([]byte, error) {
+	ipSalt.Lock()
+	defer ipSalt.Unlock()
+
+	day := time.Now().UTC().Format(time.DateOnly)
+	if ipSalt.day == day {
+		return ipSalt.salt, nil
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	salt := models.IpSalt{Day: day, Salt: hex.EncodeToString(random)}
+	if err := database.Db.Clauses(clause.OnConflict{DoNothing: true}).Create(&salt).Error; err != nil {
+		return nil, err
+	}
+	// another instance may have created it first
+	if err := database.Db.First(&salt, "day = ?", day).Error; err != nil {
+		return nil, err
+	}
+	if err := database.Db.Where("day < ?", day).Delete(&models.IpSalt{}).Error; err != nil {
+		return nil, err
+	}
+
+	ipSalt.day = day
+	ipSalt.salt = []byte(salt.Salt)
+
+	return ipSalt.salt, nil
+}
+
+// anonymizeIp transforms an IP address according to the mode.
+func anonymizeIp(ip string, mode string) string {
+	switch mode {
+	case models.IpTruncated:
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return ""
+		}
+		if v4 := addr.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return addr.Mask(net.CIDRMask(48, 128)).String()
+	case models.IpHashed:
+		salt, err := dailySalt()
+		if err != nil {
+			// better lose the address than store it in clear
+			return ""
+		}
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(ip))
+		return hex.EncodeToString(mac.Sum(nil)[:16])
+	case models.IpNone:
+		return ""
+	default:
+		return ip
+	}
+}
+
+// ipMode returns the anonymization mode of the records of a spy, the spy
+// setting taking precedence over the one of its owner.
+func ipMode(spy models.Spy) string {
+	if spy.IpMode != "" {
+		return spy.IpMode
+	}
+	if spy.User.IpMode != "" {
+		return spy.User.IpMode
+	}
+	return models.IpFull
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/models"
+)
+
+// setSalt sets the salt of the current day, so that hashing doesn't reach the
+// database.
+func setSalt(t *testing.T, salt string) {
+	t.Helper()
+
+	ipSalt.Lock()
+	ipSalt.day = time.Now().UTC().Format(time.DateOnly)
+	ipSalt.salt = []byte(salt)
+	ipSalt.Unlock()
+
+	t.Cleanup(func() {
+		ipSalt.Lock()
+		ipSalt.day = ""
+		ipSalt.salt = nil
+		ipSalt.Unlock()
+	})
+}
+
+func TestAnonymizeIp(t *testing.T) {
+	setSalt(t, "a-daily-salt")
+
+	tests := []struct {
+		name string
+		ip   string
+		mode string
+		want string
+	}{
+		{"full", "203.0.113.7", models.IpFull, "203.0.113.7"},
+		{"unknown mode", "203.0.113.7", "", "203.0.113.7"},
+		{"truncated ipv4", "203.0.113.7", models.IpTruncated, "203.0.113.0"},
+		{"truncated ipv6", "2001:db8:abcd:12::1", models.IpTruncated, "2001:db8:abcd::"},
+		{"truncated ipv4 mapped", "::ffff:203.0.113.7", models.IpTruncated, "203.0.113.0"},
+		{"truncated invalid", "unknown", models.IpTruncated, ""},
+		{"none", "203.0.113.7", models.IpNone, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := anonymizeIp(tt.ip, tt.mode); got != tt.want {
+				t.Errorf("anonymizeIp(%q, %q) = %q, want %q", tt.ip, tt.mode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnonymizeIpHashed(t *testing.T) {
+	setSalt(t, "a-daily-salt")
+
+	hash := anonymizeIp("203.0.113.7", models.IpHashed)
+	if len(hash) != 32 {
+		t.Fatalf("anonymizeIp() = %q, want 32 hex characters", hash)
+	}
+	if hash == "203.0.113.7" {
+		t.Fatal("anonymizeIp() kept the address in clear")
+	}
+	if got := anonymizeIp("203.0.113.7", models.IpHashed); got != hash {
+		t.Errorf("anonymizeIp() = %q, want the same hash %q the same day", got, hash)
+	}
+	if got := anonymizeIp("203.0.113.8", models.IpHashed); got == hash {
+		t.Error("anonymizeIp() gives two addresses the same hash")
+	}
+
+	setSalt(t, "the-salt-of-another-day")
+	if got := anonymizeIp("203.0.113.7", models.IpHashed); got == hash {
+		t.Error("anonymizeIp() gives the same hash with another salt")
+	}
+}
+
+func TestIpMode(t *testing.T) {
+	tests := []struct {
+		name string
+		spy  models.Spy
+		want string
+	}{
+		{"default", models.Spy{}, models.IpFull},
+		{"owner", models.Spy{User: models.User{IpMode: models.IpHashed}}, models.IpHashed},
+		{"spy over owner", models.Spy{IpMode: models.IpNone, User: models.User{IpMode: models.IpHashed}}, models.IpNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ipMode(tt.spy); got != tt.want {
+				t.Errorf("ipMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnrichAnonymize(t *testing.T) {
+	setSalt(t, "a-daily-salt")
+
+	tests := []struct {
+		name      string
+		mode      string
+		wantIp    string
+		wantChain []string
+	}{
+		{"full", models.IpFull, "203.0.113.7", []string{"203.0.113.7", "10.0.0.1"}},
+		{"truncated", models.IpTruncated, "203.0.113.0", []string{"203.0.113.0", "10.0.0.0"}},
+		{"none", models.IpNone, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := models.Record{Ip: "203.0.113.7", ForwardedChain: []string{"203.0.113.7", "10.0.0.1"}}
+			enrichAnonymize(&record, models.Spy{IpMode: tt.mode})
+
+			if record.IpMode != tt.mode {
+				t.Errorf("IpMode = %q, want %q", record.IpMode, tt.mode)
+			}
+			if record.Ip != tt.wantIp {
+				t.Errorf("Ip = %q, want %q", record.Ip, tt.wantIp)
+			}
+			if len(record.ForwardedChain) != len(tt.wantChain) {
+				t.Fatalf("ForwardedChain = %q, want %q", record.ForwardedChain, tt.wantChain)
+			}
+			for i := range tt.wantChain {
+				if record.ForwardedChain[i] != tt.wantChain[i] {
+					t.Errorf("ForwardedChain = %q, want %q", record.ForwardedChain, tt.wantChain)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/models"
+	"gorm.io/gorm"
+)
+
+// BeaconMaxSize is the largest beacon payload accepted, in bytes.
+const BeaconMaxSize = 4 << 10
+
+//go:embed beacon.js
+var beaconSource string
+
+var beaconScript = template.Must(template.New("beacon").Parse(beaconSource))
+
+func BeaconScript(token string) ([]byte, error) {
+	var spy models.Spy
+	if err := database.Db.Where("token = ?", token).First(&spy).Error; err != nil {
+		return nil, ServiceError{
+			Code:    404,
+			Message: "Spy not found: " + err.Error(),
+		}
+	}
+
+	var buf bytes.Buffer
+	err := beaconScript.Execute(&buf, struct{ Endpoint string }{
+		Endpoint: fmt.Sprintf("%s/b/%s", os.Getenv("TRACKING_URL"), spy.Token),
+	})
+	if err != nil {
+		return nil, ServiceError{
+			Code:    500,
+			Message: "Error while rendering beacon script: " + err.Error(),
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func Beacon(token string, req RequestContext, body []byte) error {
+	if len(body) > BeaconMaxSize {
+		return ServiceError{
+			Code:    413,
+			Message: fmt.Sprintf("Beacon payload is larger than %d bytes", BeaconMaxSize),
+		}
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ServiceError{
+			Code:    400,
+			Message: "Invalid beacon payload: " + err.Error(),
+		}
+	}
+
+	spy, err := spyByToken(token)
+	if err != nil {
+		// the database is down: the beacon is replayed once it is back
+		if !errors.Is(err, gorm.ErrRecordNotFound) && spoolBeaconHit(token, req, payload) {
+			return nil
+		}
+
+		return ServiceError{
+			Code:    404,
+			Message: "Spy not found: " + err.Error(),
+		}
+	}
+
+	record := newRecord(spy.ID, models.EventBeacon, req)
+	record.Payload = payload
+
+	if err := createRecord(&record, spy); err != nil {
+		return ServiceError{
+			Code:    500,
+			Message: "Error while creating record: " + err.Error(),
+		}
+	}
+
+	return nil
+}
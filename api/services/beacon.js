@@ -0,0 +1,36 @@
+(function () {
+	var endpoint = "{{js .Endpoint}}";
+	var start = Date.now();
+
+	function send(event) {
+		var data = JSON.stringify({
+			event: event,
+			url: location.href,
+			referrer: document.referrer,
+			title: document.title,
+			screen: screen.width + "x" + screen.height,
+			viewport: window.innerWidth + "x" + window.innerHeight,
+			language: navigator.language,
+			visibility: document.visibilityState,
+			duration_ms: Date.now() - start
+		});
+
+		if (navigator.sendBeacon) {
+			navigator.sendBeacon(endpoint, data);
+			return;
+		}
+
+		var xhr = new XMLHttpRequest();
+		xhr.open("POST", endpoint, true);
+		xhr.setRequestHeader("Content-Type", "text/plain");
+		xhr.send(data);
+	}
+
+	send("load");
+	document.addEventListener("visibilitychange", function () {
+		send(document.visibilityState);
+	});
+	window.addEventListener("pagehide", function () {
+		send("pagehide");
+	});
+})();
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/models"
+	"github.com/ZiplEix/pixel-espion/storage"
+)
+
+// SpyImageMaxSize is the largest image that can be uploaded for a spy, in bytes.
+const SpyImageMaxSize = 1 << 20
+
+var spyImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+}
+
+func UploadSpyImage(spyId string, data []byte, userId uint) error {
+	var spy models.Spy
+
+	if err := database.Db.First(&spy, spyId).Error; err != nil {
+		return ServiceError{
+			Code:    404,
+			Message: fmt.Sprintf("Spy with ID %s not found", spyId),
+		}
+	}
+
+	if spy.UserId != userId {
+		return ServiceError{
+			Code:    403,
+			Message: "Unauthorized to upload an image for this spy",
+		}
+	}
+
+	if len(data) > SpyImageMaxSize {
+		return ServiceError{
+			Code:    413,
+			Message: fmt.Sprintf("Image is larger than %d bytes", SpyImageMaxSize),
+		}
+	}
+
+	// trust the content of the file rather than the type sent by the client
+	contentType := http.DetectContentType(data)
+	if !spyImageTypes[contentType] {
+		return ServiceError{
+			Code:    415,
+			Message: "Unsupported image type: " + contentType,
+		}
+	}
+
+	key := fmt.Sprintf("spies/%d", spy.ID)
+	if err := storage.Store.Save(key, data); err != nil {
+		return ServiceError{
+			Code:    500,
+			Message: "Error while storing image: " + err.Error(),
+		}
+	}
+
+	err := database.Db.Model(&spy).Updates(map[string]any{
+		"image":      key,
+		"image_type": contentType,
+	}).Error
+	if err != nil {
+		return ServiceError{
+			Code:    500,
+			Message: "Error while updating spy: " + err.Error(),
+		}
+	}
+
+	invalidatePixel(spy.ID)
+	invalidateSpy(spy)
+
+	return nil
+}
+
+func DeleteSpyImage(spyId string, userId uint) error {
+	var spy models.Spy
+
+	if err := database.Db.First(&spy, spyId).Error; err != nil {
+		return ServiceError{
+			Code:    404,
+			Message: fmt.Sprintf("Spy with ID %s not found", spyId),
+		}
+	}
+
+	if spy.UserId != userId {
+		return ServiceError{
+			Code:    403,
+			Message: "Unauthorized to delete the image of this spy",
+		}
+	}
+
+	if spy.Image == "" {
+		return nil
+	}
+
+	if err := storage.Store.Delete(spy.Image); err != nil {
+		return ServiceError{
+			Code:    500,
+			Message: "Error while deleting image: " + err.Error(),
+		}
+	}
+
+	err := database.Db.Model(&spy).Updates(map[string]any{
+		"image":      "",
+		"image_type": "",
+	}).Error
+	if err != nil {
+		return ServiceError{
+			Code:    500,
+			Message: "Error while updating spy: " + err.Error(),
+		}
+	}
+
+	invalidatePixel(spy.ID)
+	invalidateSpy(spy)
+
+	return nil
+}
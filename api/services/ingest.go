@@ -0,0 +1,190 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/env"
+	"github.com/ZiplEix/pixel-espion/models"
+)
+
+// Defaults of the INGEST_* env vars.
+const (
+	defaultIngestQueue    = 10000
+	defaultIngestWorkers  = 4
+	defaultIngestBatch    = 100
+	defaultIngestInterval = time.Second
+)
+
+type ingestJob struct {
+	record models.Record
+	spy    models.Spy
+}
+
+// ingest is the pipeline storing the records in the background, so that the
+// tracking endpoints don't wait on the database. Each worker has its own
+// queue, and the records of a visitor always go to the same one, so that they
+// are classified in order.
+var ingest struct {
+	sync.RWMutex // held for writing while the queues are opened or closed
+	queues       []chan ingestJob
+	batchSize    int
+	interval     time.Duration
+	workers      sync.WaitGroup
+}
+
+// StartIngest starts the ingestion workers, configured by the env vars:
+//   - INGEST_QUEUE: how many records may wait to be stored, the ones beyond
+//     are stored by the request itself
+//   - INGEST_WORKERS: how many batches are written at once
+//   - INGEST_BATCH_SIZE: the most records written in one statement
+//   - INGEST_FLUSH_INTERVAL: how long a record may wait for its batch to fill
+//     up, such as "1s"
+func StartIngest() error {
+	fmt.Println("Starting record ingestion...")
+
+	size, err := env.Int("INGEST_QUEUE", defaultIngestQueue)
+	if err != nil {
+		return err
+	}
+	workers, err := env.Int("INGEST_WORKERS", defaultIngestWorkers)
+	if err != nil {
+		return err
+	}
+	batchSize, err := env.Int("INGEST_BATCH_SIZE", defaultIngestBatch)
+	if err != nil {
+		return err
+	}
+	interval, err := env.Duration("INGEST_FLUSH_INTERVAL", defaultIngestInterval)
+	if err != nil {
+		return err
+	}
+
+	ingest.Lock()
+	defer ingest.Unlock()
+
+	ingest.batchSize = batchSize
+	ingest.interval = interval
+	ingest.queues = make([]chan ingestJob, workers)
+	for i := range ingest.queues {
+		// the queue size is shared between the workers
+		ingest.queues[i] = make(chan ingestJob, (size+workers-1)/workers)
+		ingest.workers.Add(1)
+		go ingestWorker(ingest.queues[i])
+	}
+
+	return nil
+}
+
+// StopIngest stops accepting records and waits for the pending ones to be
+// stored. The records created afterwards are stored right away.
+func StopIngest() {
+	ingest.Lock()
+	for _, queue := range ingest.queues {
+		close(queue)
+	}
+	ingest.queues = nil
+	ingest.Unlock()
+
+	ingest.workers.Wait()
+}
+
+// IngestQueue returns how many records are waiting to be stored, and how many
+// may wait at most.
+func IngestQueue() (depth int, capacity int) {
+	ingest.RLock()
+	defer ingest.RUnlock()
+
+	for _, queue := range ingest.queues {
+		depth += len(queue)
+		capacity += cap(queue)
+	}
+
+	return depth, capacity
+}
+
+// enqueueRecord hands the record over to its worker, and returns false if
+// the pipeline isn't running or the queue of the worker is full.
+func enqueueRecord(record models.Record, spy models.Spy) bool {
+	ingest.RLock()
+	defer ingest.RUnlock()
+
+	if len(ingest.queues) == 0 {
+		return false
+	}
+
+	// same inputs as the visitor key, which is only computed by the worker
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d\x00%s\x00", record.SpyID, record.Ip)
+	if record.UserAgent != nil {
+		h.Write([]byte(*record.UserAgent))
+	}
+	h.Write([]byte{0})
+	h.Write([]byte(record.Recipient))
+	queue := ingest.queues[h.Sum32()%uint32(len(ingest.queues))]
+
+	select {
+	case queue <- ingestJob{record: record, spy: spy}:
+		return true
+	default:
+		return false
+	}
+}
+
+// flushBatch stores the batches of the workers. The batch is reused once it
+// returns.
+var flushBatch = flushRecords
+
+func ingestWorker(queue chan ingestJob) {
+	defer ingest.workers.Done()
+
+	batch := make([]models.Record, 0, ingest.batchSize)
+	ticker := time.NewTicker(ingest.interval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) > 0 {
+			flushBatch(batch)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case job, ok := <-queue:
+			if !ok {
+				flush()
+				return
+			}
+
+			enrichRecord(&job.record, job.spy)
+			batch = append(batch, job.record)
+			if len(batch) >= ingest.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// flushRecords stores a batch of records. When the batch fails, it goes to the
+// spool if the database is down, or else its records are stored one by one so
+// that a single bad record doesn't lose the others.
+func flushRecords(batch []models.Record) {
+	if err := storeRecords(batch); err == nil {
+		return
+	}
+	if spoolRecords(batch) {
+		return
+	}
+
+	for i := range batch {
+		batch[i].ID = 0
+		if err := storeRecords(batch[i : i+1]); err != nil {
+			fmt.Printf("Error while storing a record of spy %d: %v\n", batch[i].SpyID, err)
+		}
+	}
+}
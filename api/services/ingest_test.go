@@ -0,0 +1,140 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/models"
+)
+
+// startIngest starts the pipeline with the settings, without enrichment, the
+// batches it flushes being sent to the returned channel. flush is called
+// before that, if not nil.
+func startIngest(t *testing.T, queue string, batchSize string, interval string, flush func()) chan []models.Record {
+	t.Helper()
+
+	previousEnrichers, previousFlush := enrichers, flushBatch
+	batches := make(chan []models.Record, 100)
+	enrichers = nil
+	flushBatch = func(batch []models.Record) {
+		if flush != nil {
+			flush()
+		}
+		batches <- append([]models.Record(nil), batch...)
+	}
+
+	t.Setenv("INGEST_QUEUE", queue)
+	t.Setenv("INGEST_WORKERS", "1")
+	t.Setenv("INGEST_BATCH_SIZE", batchSize)
+	t.Setenv("INGEST_FLUSH_INTERVAL", interval)
+	if err := StartIngest(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		StopIngest()
+		enrichers, flushBatch = previousEnrichers, previousFlush
+	})
+
+	return batches
+}
+
+// enqueue hands records of the spy over to the pipeline, and fails the test
+// if one isn't taken.
+func enqueue(t *testing.T, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		record := models.Record{SpyID: 1, Ip: "203.0.113." + strconv.Itoa(i)}
+		if !enqueueRecord(record, models.Spy{}) {
+			t.Fatalf("enqueueRecord() = false for record %d", i)
+		}
+	}
+}
+
+// nextBatch waits for a batch to be flushed.
+func nextBatch(t *testing.T, batches chan []models.Record, wait time.Duration) []models.Record {
+	t.Helper()
+
+	select {
+	case batch := <-batches:
+		return batch
+	case <-time.After(wait):
+		t.Fatalf("no batch flushed within %s", wait)
+		return nil
+	}
+}
+
+func TestIngestFlushOnSize(t *testing.T) {
+	batches := startIngest(t, "", "3", "1h", nil)
+
+	enqueue(t, 7)
+	for i := 0; i < 2; i++ {
+		if batch := nextBatch(t, batches, time.Second); len(batch) != 3 {
+			t.Errorf("batch of %d records, want 3", len(batch))
+		}
+	}
+
+	select {
+	case batch := <-batches:
+		t.Errorf("batch of %d records flushed before the batch size or the interval", len(batch))
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	StopIngest()
+	if batch := nextBatch(t, batches, time.Second); len(batch) != 1 {
+		t.Errorf("batch of %d records on stop, want the pending one", len(batch))
+	}
+}
+
+func TestIngestFlushOnInterval(t *testing.T) {
+	batches := startIngest(t, "", "100", "50ms", nil)
+
+	start := time.Now()
+	enqueue(t, 2)
+	if batch := nextBatch(t, batches, time.Second); len(batch) != 2 {
+		t.Errorf("batch of %d records, want 2", len(batch))
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("batch flushed after %s, want it within the interval", elapsed)
+	}
+}
+
+func TestIngestQueueFull(t *testing.T) {
+	flushing := make(chan struct{})
+	release := make(chan struct{})
+	batches := startIngest(t, "1", "1", "1h", func() {
+		flushing <- struct{}{}
+		<-release
+	})
+
+	// the worker is stuck flushing the first record, the second one waits in
+	// the queue and the third one finds it full
+	enqueue(t, 1)
+	<-flushing
+	enqueue(t, 1)
+	if enqueueRecord(models.Record{SpyID: 1}, models.Spy{}) {
+		t.Error("enqueueRecord() = true with a full queue")
+	}
+	if depth, capacity := IngestQueue(); depth != 1 || capacity != 1 {
+		t.Errorf("IngestQueue() = %d, %d, want 1, 1", depth, capacity)
+	}
+
+	close(release)
+	nextBatch(t, batches, time.Second)
+	<-flushing
+	nextBatch(t, batches, time.Second)
+}
+
+func TestIngestStopped(t *testing.T) {
+	startIngest(t, "", "", "", nil)
+	StopIngest()
+
+	if enqueueRecord(models.Record{SpyID: 1}, models.Spy{}) {
+		t.Error("enqueueRecord() = true once stopped")
+	}
+	if depth, capacity := IngestQueue(); depth != 0 || capacity != 0 {
+		t.Errorf("IngestQueue() = %d, %d once stopped, want 0, 0", depth, capacity)
+	}
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/models"
+	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+)
+
+type LinkStats struct {
+	models.Link
+	Clicks int64
+}
+
+func Click(token string, req RequestContext) (string, error) {
+	var link models.Link
+	if err := database.Db.Preload("Spy.User").Where("token = ?", token).First(&link).Error; err != nil {
+		return "", ServiceError{
+			Code:    404,
+			Message: "Link not found: " + err.Error(),
+		}
+	}
+
+	// the spy of the link was deleted
+	if link.Spy.ID == 0 {
+		return "", ServiceError{
+			Code:    404,
+			Message: "Link not found",
+		}
+	}
+
+	record := newRecord(link.SpyID, models.EventClick, req)
+	record.LinkID = &link.ID
+
+	if err := createRecord(&record, link.Spy); err != nil {
+		return "", ServiceError{
+			Code:    500,
+			Message: "Error while creating click: " + err.Error(),
+		}
+	}
+
+	fmt.Printf("Link '%s' has been clicked by '%s'\n", link.Name, req.Ip)
+
+	return link.Url, nil
+}
+
+func NewLink(req requestmodels.NewLinkRequest, userId uint) (models.Link, error) {
+	var spy models.Spy
+
+	if err := database.Db.First(&spy, req.SpyId).Error; err != nil {
+		return models.Link{}, ServiceError{
+			Code:    404,
+			Message: fmt.Sprintf("Spy with ID %d not found", req.SpyId),
+		}
+	}
+
+	if spy.UserId != userId {
+		return models.Link{}, ServiceError{
+			Code:    403,
+			Message: "Unauthorized to add a link to this spy",
+		}
+	}
+
+	link := models.Link{
+		Name:  req.Name,
+		Url:   req.Url,
+		SpyID: spy.ID,
+	}
+
+	if err := database.Db.Create(&link).Error; err != nil {
+		return models.Link{}, ServiceError{
+			Code:    500,
+			Message: "Error while creating link: " + err.Error(),
+		}
+	}
+
+	return link, nil
+}
+
+func GetAllLinks(userId uint) ([]models.Link, error) {
+	var links []models.Link
+
+	if err := database.Db.Joins("JOIN spies ON spies.id = links.spy_id").Where("spies.user_id = ?", userId).Find(&links).Error; err != nil {
+		return nil, ServiceError{
+			Code:    500,
+			Message: "Error while fetching links: " + err.Error(),
+		}
+	}
+
+	return links, nil
+}
+
+func GetLink(linkId string, userId uint) (*models.Link, error) {
+	var link models.Link
+
+	if err := database.Db.Preload("Spy").First(&link, linkId).Error; err != nil {
+		return nil, ServiceError{
+			Code:    404,
+			Message: fmt.Sprintf("Link with ID %s not found", linkId),
+		}
+	}
+
+	if link.Spy.UserId != userId {
+		return nil, ServiceError{
+			Code:    403,
+			Message: "Unauthorized to access this link",
+		}
+	}
+
+	return &link, nil
+}
+
+// GetSpyLinkStats returns the links of a spy along with their click count.
+func GetSpyLinkStats(spyId string) ([]LinkStats, error) {
+	var stats []LinkStats
+
+	err := database.Db.Model(&models.Link{}).
+		Select("links.*, COUNT(records.id) AS clicks").
+		Joins("LEFT JOIN records ON records.link_id = links.id AND records.event_type = ? AND NOT records.suspicious AND records.deleted_at IS NULL", models.EventClick).
+		Where("links.spy_id = ?", spyId).
+		Group("links.id").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, ServiceError{
+			Code:    500,
+			Message: "Error while retrieving link clicks: " + err.Error(),
+		}
+	}
+
+	return stats, nil
+}
+
+func UpdateLink(linkId string, req requestmodels.UpdateLinkRequest, userId uint) error {
+	link, err := GetLink(linkId, userId)
+	if err != nil {
+		return err
+	}
+
+	link.Name = req.Name
+	link.Url = req.Url
+
+	if err := database.Db.Omit("Spy").Save(link).Error; err != nil {
+		return ServiceError{
+			Code:    500,
+			Message: "Error while updating link: " + err.Error(),
+		}
+	}
+
+	return nil
+}
+
+func DeleteLink(linkId string, userId uint) error {
+	link, err := GetLink(linkId, userId)
+	if err != nil {
+		return err
+	}
+
+	if err := database.Db.Delete(link).Error; err != nil {
+		return ServiceError{
+			Code:    500,
+			Message: "Error while deleting link: " + err.Error(),
+		}
+	}
+
+	return nil
+}
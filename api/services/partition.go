@@ -0,0 +1,50 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/database"
+)
+
+// partitionMaintenanceInterval is how often the partitions of the records are
+// created and dropped.
+const partitionMaintenanceInterval = 24 * time.Hour
+
+// MaintainPartitions creates the partitions of the records for the coming
+// months, and drops the ones past RECORDS_RETENTION_MONTHS. It returns the
+// names of the partitions dropped.
+func MaintainPartitions() ([]string, error) {
+	dropped, err := database.MaintainPartitions()
+	if err != nil {
+		return dropped, ServiceError{
+			Code:    500,
+			Message: "Error while maintaining record partitions: " + err.Error(),
+		}
+	}
+
+	return dropped, nil
+}
+
+// StartPartitionMaintenance maintains the partitions of the records now, then
+// once a day in the background. Failures are only logged: until the
+// partitions are fixed, the records land in the default one.
+func StartPartitionMaintenance() {
+	maintain := func() {
+		dropped, err := MaintainPartitions()
+		for _, name := range dropped {
+			fmt.Printf("Record partition '%s' dropped\n", name)
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	maintain()
+
+	go func() {
+		for range time.Tick(partitionMaintenanceInterval) {
+			maintain()
+		}
+	}()
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ZiplEix/pixel-espion/models"
+	"github.com/ZiplEix/pixel-espion/storage"
+)
+
+type PixelFormat string
+
+const (
+	PixelPNG  PixelFormat = "png"
+	PixelGIF  PixelFormat = "gif"
+	PixelWebP PixelFormat = "webp"
+	PixelSVG  PixelFormat = "svg"
+)
+
+var pixelContentTypes = map[PixelFormat]string{
+	PixelPNG:  "image/png",
+	PixelGIF:  "image/gif",
+	PixelWebP: "image/webp",
+	PixelSVG:  "image/svg+xml",
+}
+
+// PixelContentTypes lists the content types a pixel can be served as, in
+// order of preference for content negotiation.
+var PixelContentTypes = []string{"image/png", "image/gif", "image/webp", "image/svg+xml"}
+
+func (f PixelFormat) ContentType() string {
+	return pixelContentTypes[f]
+}
+
+// ParsePixelFormat returns the format matching a path extension.
+func ParsePixelFormat(ext string) (PixelFormat, bool) {
+	f := PixelFormat(strings.ToLower(ext))
+	_, ok := pixelContentTypes[f]
+	return f, ok
+}
+
+// PixelFormatFromContentType returns the format matching a content type, PNG
+// being the default.
+func PixelFormatFromContentType(contentType string) PixelFormat {
+	for f, ct := range pixelContentTypes {
+		if ct == contentType {
+			return f
+		}
+	}
+	return PixelPNG
+}
+
+type pixelKey struct {
+	spyId  uint
+	format PixelFormat
+}
+
+type pixelImage struct {
+	data        []byte
+	contentType string
+}
+
+type pixelCacheEntry struct {
+	key pixelKey
+	img pixelImage
+}
+
+// maxPixelCache bounds the number of images kept by pixelCache.
+const maxPixelCache = 10000
+
+// pixelCache keeps the rendered images of each spy so that Pixel1 doesn't
+// encode the same image on every visit, in least recently used order. Entries
+// are dropped when the spy's appearance changes. Uploaded images, which may be
+// large, are read from the storage instead.
+var pixelCache = struct {
+	sync.Mutex
+	order  *list.List // of *pixelCacheEntry, most recently used first
+	images map[pixelKey]*list.Element
+}{order: list.New(), images: make(map[pixelKey]*list.Element)}
+
+func parseHexColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+
+	// expand the short forms (#rgb, #rgba) to their long equivalent
+	if len(hex) == 3 || len(hex) == 4 {
+		var long strings.Builder
+		for _, r := range hex {
+			long.WriteRune(r)
+			long.WriteRune(r)
+		}
+		hex = long.String()
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid hex color '%s'", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid hex color '%s'", s)
+	}
+
+	return color.NRGBA{
+		R: uint8(v >> 24),
+		G: uint8(v >> 16),
+		B: uint8(v >> 8),
+		A: uint8(v),
+	}, nil
+}
+
+func renderPixel(spy models.Spy, format PixelFormat) ([]byte, error) {
+	c, err := parseHexColor(spy.Color)
+	if err != nil {
+		return nil, err
+	}
+	// the spy alpha setting scales the alpha that may come with the color
+	c.A = uint8(uint(c.A) * uint(spy.Alpha) / 255)
+
+	width, height := int(spy.Width), int(spy.Height)
+	if width == 0 {
+		width = 1
+	}
+	if height == 0 {
+		height = 1
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	var buf bytes.Buffer
+
+	switch format {
+	case PixelGIF:
+		// GIF only knows about fully transparent colors
+		opaque := c
+		opaque.A = 0xff
+		img := image.NewPaletted(rect, color.Palette{opaque, color.Transparent})
+		if c.A < 0x80 {
+			for i := range img.Pix {
+				img.Pix[i] = 1
+			}
+		}
+		if err := gif.Encode(&buf, img, nil); err != nil {
+			return nil, err
+		}
+	case PixelWebP:
+		buf.Write(encodeWebP(c, width, height))
+	case PixelSVG:
+		fmt.Fprintf(&buf,
+			`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d"><rect width="%d" height="%d" fill="#%02x%02x%02x" fill-opacity="%.3f"/></svg>`,
+			width, height, width, height, c.R, c.G, c.B, float64(c.A)/255,
+		)
+	default:
+		img := image.NewNRGBA(rect)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// loadImage returns the image uploaded for the spy, or the pixel rendered in
+// the requested format when there is none.
+func loadImage(spy models.Spy, format PixelFormat) (pixelImage, error) {
+	if spy.Image != "" {
+		data, err := storage.Store.Load(spy.Image)
+		if err == nil {
+			return pixelImage{data: data, contentType: spy.ImageType}, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return pixelImage{}, err
+		}
+	}
+
+	data, err := renderPixel(spy, format)
+	if err != nil {
+		return pixelImage{}, err
+	}
+
+	return pixelImage{data: data, contentType: format.ContentType()}, nil
+}
+
+// getPixel returns the image served for the spy along with its content type.
+func getPixel(spy models.Spy, format PixelFormat) ([]byte, string, error) {
+	if spy.Image != "" {
+		img, err := loadImage(spy, format)
+		return img.data, img.contentType, err
+	}
+
+	key := pixelKey{spyId: spy.ID, format: format}
+
+	pixelCache.Lock()
+	if elem, ok := pixelCache.images[key]; ok {
+		pixelCache.order.MoveToFront(elem)
+		img := elem.Value.(*pixelCacheEntry).img
+		pixelCache.Unlock()
+		return img.data, img.contentType, nil
+	}
+	pixelCache.Unlock()
+
+	img, err := loadImage(spy, format)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pixelCache.Lock()
+	if elem, ok := pixelCache.images[key]; ok {
+		pixelCache.order.Remove(elem)
+	}
+	pixelCache.images[key] = pixelCache.order.PushFront(&pixelCacheEntry{key: key, img: img})
+	for pixelCache.order.Len() > maxPixelCache {
+		removePixelEntry(pixelCache.order.Back())
+	}
+	pixelCache.Unlock()
+
+	return img.data, img.contentType, nil
+}
+
+// removePixelEntry drops an entry of the cache. The lock must be held.
+func removePixelEntry(elem *list.Element) {
+	entry := pixelCache.order.Remove(elem).(*pixelCacheEntry)
+	delete(pixelCache.images, entry.key)
+}
+
+func invalidatePixel(spyId uint) {
+	pixelCache.Lock()
+	for key, elem := range pixelCache.images {
+		if key.spyId == spyId {
+			removePixelEntry(elem)
+		}
+	}
+	pixelCache.Unlock()
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/models"
+	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RecipientStatus is a recipient of a spy along with its opens. Suspicious
+// records are not counted.
+type RecipientStatus struct {
+	models.Recipient
+	Opens     int64
+	FirstOpen *time.Time
+	LastOpen  *time.Time
+}
+
+type RecipientUrl struct {
+	RecipientID uint
+	Email       string
+	Url         string
+}
+
+// linkRecipient links the record of a pixel hit to the attached recipient the
+// pixel url was minted for, found from its token or else from the signed
+// recipient email. A record matching no recipient is left as is.
+func linkRecipient(record *models.Record) error {
+	var recipient models.Recipient
+
+	query := database.Db.Where("spy_id = ?", record.SpyID)
+	switch {
+	case record.RcptToken != "":
+		query = query.Where("token = ?", record.RcptToken)
+	case record.Recipient != "" && record.SignatureStatus == models.SignatureValid:
+		query = query.Where("email = ?", record.Recipient)
+	default:
+		return nil
+	}
+
+	err := query.First(&recipient).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	record.RecipientID = &recipient.ID
+	if record.Recipient == "" {
+		record.Recipient = recipient.Email
+	}
+
+	return nil
+}
+
+// AttachRecipients adds the recipients to the spy. Recipients already attached
+// with the same email are updated, and brought back if they were removed.
+func AttachRecipients(spyId string, req requestmodels.AttachRecipientsRequest, userId uint) ([]models.Recipient, error) {
+	spy, err := getOwnedSpy(spyId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	// the request may list an email twice, the last one wins
+	index := make(map[string]int)
+	var recipients []models.Recipient
+	for _, r := range req.Recipients {
+		recipient := models.Recipient{
+			Email:  r.Email,
+			Name:   r.Name,
+			Fields: r.Fields,
+			SpyID:  spy.ID,
+		}
+		if i, ok := index[r.Email]; ok {
+			recipients[i] = recipient
+			continue
+		}
+		index[r.Email] = len(recipients)
+		recipients = append(recipients, recipient)
+	}
+
+	updates := clause.AssignmentColumns([]string{"name", "fields", "updated_at"})
+	updates = append(updates, clause.Assignment{Column: clause.Column{Name: "deleted_at"}, Value: nil})
+
+	err = database.Db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "spy_id"}, {Name: "email"}},
+		DoUpdates: updates,
+	}).Create(&recipients).Error
+	if err != nil {
+		return nil, ServiceError{
+			Code:    500,
+			Message: "Error while attaching recipients: " + err.Error(),
+		}
+	}
+
+	// reload them, the token and id of the updated ones are the stored ones
+	emails := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		emails[i] = recipient.Email
+	}
+	if err := database.Db.Where("spy_id = ? AND email IN ?", spy.ID, emails).Find(&recipients).Error; err != nil {
+		return nil, ServiceError{
+			Code:    500,
+			Message: "Error while retrieving recipients: " + err.Error(),
+		}
+	}
+
+	return recipients, nil
+}
+
+// GetSpyRecipients returns the recipients of the spy with their opens,
+// restricted to the ones who opened it or not with filter.Opened.
+func GetSpyRecipients(spyId string, filter requestmodels.RecipientFilter, userId uint) ([]RecipientStatus, error) {
+	spy, err := getOwnedSpy(spyId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []RecipientStatus
+
+	query := database.Db.Model(&models.Recipient{}).
+		Select("recipients.*, COUNT(records.id) AS opens, MIN(records.time) AS first_open, MAX(records.time) AS last_open").
+		Joins("LEFT JOIN records ON records.recipient_id = recipients.id AND records.event_type = ? AND NOT records.suspicious AND records.deleted_at IS NULL", models.EventOpen).
+		Where("recipients.spy_id = ?", spy.ID).
+		Group("recipients.id").
+		Order("recipients.email")
+	if filter.Opened != nil {
+		if *filter.Opened {
+			query = query.Having("COUNT(records.id) > 0")
+		} else {
+			query = query.Having("COUNT(records.id) = 0")
+		}
+	}
+
+	if err := query.Scan(&recipients).Error; err != nil {
+		return nil, ServiceError{
+			Code:    500,
+			Message: "Error while retrieving recipients: " + err.Error(),
+		}
+	}
+
+	return recipients, nil
+}
+
+// RecipientUrls mints the pixel url of each recipient of the spy.
+func RecipientUrls(spyId string, req requestmodels.RecipientUrlsRequest, userId uint) ([]RecipientUrl, error) {
+	spy, err := getOwnedSpy(spyId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []models.Recipient
+	if err := database.Db.Where("spy_id = ?", spy.ID).Order("email").Find(&recipients).Error; err != nil {
+		return nil, ServiceError{
+			Code:    500,
+			Message: "Error while retrieving recipients: " + err.Error(),
+		}
+	}
+
+	sentAt := strconv.FormatInt(time.Now().Unix(), 10)
+	urls := make([]RecipientUrl, len(recipients))
+	for i, recipient := range recipients {
+		values := url.Values{}
+		values.Set(paramRcptToken, recipient.Token)
+		values.Set(paramSentAt, sentAt)
+		if req.Campaign != "" {
+			values.Set(paramCampaign, req.Campaign)
+		}
+
+		urls[i] = RecipientUrl{
+			RecipientID: recipient.ID,
+			Email:       recipient.Email,
+			Url:         signedPixelUrl(spy, values, req.Format),
+		}
+	}
+
+	return urls, nil
+}
+
+func DeleteRecipient(spyId string, recipientId string, userId uint) error {
+	spy, err := getOwnedSpy(spyId, userId)
+	if err != nil {
+		return err
+	}
+
+	result := database.Db.Where("spy_id = ?", spy.ID).Delete(&models.Recipient{}, recipientId)
+	if result.Error != nil {
+		return ServiceError{
+			Code:    500,
+			Message: "Error while deleting recipient: " + result.Error.Error(),
+		}
+	}
+	if result.RowsAffected == 0 {
+		return ServiceError{
+			Code:    404,
+			Message: fmt.Sprintf("Recipient with ID %s not found", recipientId),
+		}
+	}
+
+	return nil
+}
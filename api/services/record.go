@@ -0,0 +1,327 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/botdetect"
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/geoip"
+	"github.com/ZiplEix/pixel-espion/mailproxy"
+	"github.com/ZiplEix/pixel-espion/models"
+	"github.com/ZiplEix/pixel-espion/rdns"
+	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+	"github.com/ZiplEix/pixel-espion/useragent"
+	"gorm.io/gorm"
+)
+
+// RequestContext holds what is known about the request that triggered a
+// record.
+type RequestContext struct {
+	Ip             string
+	ForwardedChain []string // from the client to our peer, when behind proxies
+	UserAgent      string
+	Referer        string
+	AcceptLanguage string
+	Host           string
+	Headers        map[string]string // allowlisted by RECORD_HEADERS
+	Query          map[string]string
+}
+
+var recordHeaders struct {
+	once  sync.Once
+	names []string
+}
+
+// RecordedHeaders returns the canonical names of the extra headers stored on
+// the records, as configured by the comma separated RECORD_HEADERS env var.
+func RecordedHeaders() []string {
+	recordHeaders.once.Do(func() {
+		for _, name := range strings.Split(os.Getenv("RECORD_HEADERS"), ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				recordHeaders.names = append(recordHeaders.names, http.CanonicalHeaderKey(name))
+			}
+		}
+	})
+
+	return recordHeaders.names
+}
+
+// newRecord returns a record of the spy for the given request.
+func newRecord(spyId uint, eventType string, req RequestContext) models.Record {
+	return models.Record{
+		Ip:             req.Ip,
+		ForwardedChain: req.ForwardedChain,
+		Time:           time.Now(),
+		EventType:      eventType,
+		UserAgent:      &req.UserAgent,
+		Referer:        &req.Referer,
+		AcceptLanguage: &req.AcceptLanguage,
+		Host:           &req.Host,
+		Headers:        req.Headers,
+		SpyID:          spyId,
+	}
+}
+
+// defaultBotThreshold is the bot score above which the hits of a spy are
+// flagged as suspicious, unless the spy sets its own.
+const defaultBotThreshold = 50
+
+// enrichers complete a record of a spy with what can be derived from the
+// request, before it is stored. They run in order.
+var enrichers = []func(record *models.Record, spy models.Spy){
+	enrichUserAgent,
+	enrichGeo,
+	enrichProxy,
+	enrichBot,
+	// before the visit, the recipient being part of the visitor key
+	enrichRecipient,
+	enrichVisit,
+	// must stay last, every enricher above needs the address in clear
+	enrichAnonymize,
+}
+
+func enrichUserAgent(record *models.Record, spy models.Spy) {
+	if record.UserAgent == nil {
+		return
+	}
+
+	info := useragent.Parse(*record.UserAgent)
+	record.Browser = info.Browser
+	record.BrowserVersion = info.BrowserVersion
+	record.OS = info.OS
+	record.OSVersion = info.OSVersion
+	record.Device = info.Device
+	record.EmailClient = info.EmailClient
+}
+
+func enrichGeo(record *models.Record, spy models.Spy) {
+	loc, ok := geoip.Lookup(record.Ip)
+	if !ok {
+		return
+	}
+
+	record.Country = loc.Country
+	record.Region = loc.Region
+	record.City = loc.City
+	record.Latitude = loc.Latitude
+	record.Longitude = loc.Longitude
+	record.ASN = loc.ASN
+	record.ASOrg = loc.ASOrg
+}
+
+func enrichProxy(record *models.Record, spy models.Spy) {
+	userAgent := ""
+	if record.UserAgent != nil {
+		userAgent = *record.UserAgent
+	}
+
+	record.ProxyClass, record.ProxyName = mailproxy.Classify(record.Ip, userAgent)
+}
+
+func enrichBot(record *models.Record, spy models.Spy) {
+	userAgent := ""
+	if record.UserAgent != nil {
+		userAgent = *record.UserAgent
+	}
+
+	record.BotScore, record.BotReason = botdetect.Score(botdetect.Hit{
+		SpyID:     record.SpyID,
+		Ip:        record.Ip,
+		UserAgent: userAgent,
+		Device:    record.Device,
+		Time:      record.Time,
+		SentAt:    record.SentAt,
+	})
+	record.Suspicious = record.BotScore > botThreshold(spy)
+}
+
+// botThreshold returns the bot score above which the hits of the spy are
+// suspicious.
+func botThreshold(spy models.Spy) int {
+	if spy.BotThreshold == 0 {
+		return defaultBotThreshold
+	}
+	return spy.BotThreshold
+}
+
+// enrichRecipient links the record to its attached recipient. On a failure,
+// the record is stored unlinked, or linked on replay if it is spooled.
+func enrichRecipient(record *models.Record, spy models.Spy) {
+	if err := linkRecipient(record); err != nil {
+		fmt.Printf("Error while resolving the recipient of a record of spy %d: %v\n", record.SpyID, err)
+	}
+}
+
+func enrichAnonymize(record *models.Record, spy models.Spy) {
+	record.IpMode = ipMode(spy)
+	if record.IpMode == models.IpFull {
+		return
+	}
+
+	record.Ip = anonymizeIp(record.Ip, record.IpMode)
+	if record.IpMode == models.IpNone {
+		record.ForwardedChain = nil
+		return
+	}
+
+	chain := make([]string, len(record.ForwardedChain))
+	for i, hop := range record.ForwardedChain {
+		chain[i] = anonymizeIp(hop, record.IpMode)
+	}
+	record.ForwardedChain = chain
+}
+
+func enrichRecord(record *models.Record, spy models.Spy) {
+	for _, enrich := range enrichers {
+		enrich(record, spy)
+	}
+}
+
+// storeRecords writes enriched records in a single statement along with
+// their rollups, then schedules the lookup of their hostname.
+func storeRecords(records []models.Record) error {
+	err := database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&records).Error; err != nil {
+			return err
+		}
+		return updateRollups(tx, records, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		lookupHostname(record)
+	}
+
+	return nil
+}
+
+// lookupHostname schedules the lookup of the hostname of a stored record.
+func lookupHostname(record models.Record) {
+	// the hostname would give away an anonymized address
+	if record.IpMode != models.IpFull {
+		return
+	}
+
+	id := record.ID
+	rdns.Enqueue(record.Ip, func(hostname string) {
+		err := database.Db.Model(&models.Record{}).Where("id = ?", id).Update("hostname", hostname).Error
+		if err != nil {
+			fmt.Printf("Error while saving the hostname of record %d: %v\n", id, err)
+		}
+	})
+}
+
+// createRecord hands a record of the spy over to the ingestion pipeline, which
+// enriches and stores it in the background. It is only stored right away, and
+// an error returned, when the pipeline isn't running or is full. While the
+// database is down, the record goes to the spool instead.
+func createRecord(record *models.Record, spy models.Spy) error {
+	if enqueueRecord(*record, spy) {
+		return nil
+	}
+
+	enrichRecord(record, spy)
+	err := storeRecords([]models.Record{*record})
+	if err != nil && spoolRecords([]models.Record{*record}) {
+		return nil
+	}
+
+	return err
+}
+
+type RecordGroup struct {
+	Value string
+	Count int64
+}
+
+var recordGroupColumns = map[string]string{
+	"browser":      "records.browser",
+	"os":           "records.os",
+	"device":       "records.device",
+	"email_client": "records.email_client",
+	"proxy":        "records.proxy_class",
+}
+
+// filterRecords restricts a query on the records table to the ones matching
+// the filter.
+func filterRecords(query *gorm.DB, filter requestmodels.RecordFilter) *gorm.DB {
+	if filter.Type != "" {
+		query = query.Where("records.event_type = ?", filter.Type)
+	}
+	if filter.Browser != "" {
+		query = query.Where("records.browser = ?", filter.Browser)
+	}
+	if filter.OS != "" {
+		query = query.Where("records.os = ?", filter.OS)
+	}
+	if filter.Device != "" {
+		query = query.Where("records.device = ?", filter.Device)
+	}
+	if filter.EmailClient != "" {
+		query = query.Where("records.email_client = ?", filter.EmailClient)
+	}
+	if filter.Proxy != "" {
+		query = query.Where("records.proxy_class = ?", filter.Proxy)
+	}
+	if filter.Visit != "" {
+		query = query.Where("records.visit = ?", filter.Visit)
+	}
+	if filter.ExcludeSuspicious {
+		query = query.Where("NOT records.suspicious")
+	}
+	if filter.ExcludeProxied {
+		query = query.Where("records.proxy_class IS NULL OR records.proxy_class NOT IN ?", []string{mailproxy.Proxied, mailproxy.Prefetched})
+	}
+
+	return query
+}
+
+// BackfillGeo locates the records stored without a location, and returns how
+// many of them were updated. Records whose address was anonymized are left
+// alone, it can't be located.
+func BackfillGeo() (int, error) {
+	if !geoip.Enabled() {
+		return 0, ServiceError{
+			Code:    500,
+			Message: "No GeoIP database loaded, check GEOIP_DB",
+		}
+	}
+
+	updated := 0
+	var records []models.Record
+
+	query := database.Db.Where("ip_mode = ?", models.IpFull).Where("country IS NULL OR country = ''")
+	err := query.FindInBatches(&records, 500, func(tx *gorm.DB, batch int) error {
+		for i := range records {
+			enrichGeo(&records[i], models.Spy{})
+			if records[i].Country == "" && records[i].ASN == nil {
+				continue
+			}
+
+			err := database.Db.Model(&records[i]).
+				Select("country", "region", "city", "latitude", "longitude", "asn", "as_org").
+				Updates(&records[i]).Error
+			if err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return updated, ServiceError{
+			Code:    500,
+			Message: "Error while backfilling record locations: " + err.Error(),
+		}
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/models"
+	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Longest ranges of the stats, so that a request can't ask for millions of
+// buckets.
+const (
+	maxHourlyStatsRange = 31 * 24 * time.Hour
+	maxDailyStatsRange  = 2 * 366 * 24 * time.Hour
+)
+
+type rollupKey struct {
+	spyId     uint
+	eventType string
+	bucket    time.Time
+}
+
+// SpyStats are the counts of the records of a spy over a period.
+type SpyStats struct {
+	SpyID  uint
+	Name   string
+	Total  int64
+	Human  int64
+	Unique int64 `gorm:"column:uniques"`
+}
+
+// updateRollups adds the records to the counts of their buckets, or removes
+// them with a negative sign. It must run in the transaction storing or
+// deleting the records.
+func updateRollups(tx *gorm.DB, records []models.Record, sign int64) error {
+	hourly := make(map[rollupKey]*models.Rollup)
+	daily := make(map[rollupKey]*models.Rollup)
+
+	for _, record := range records {
+		t := record.Time.UTC()
+		addToRollup(hourly, record, t.Truncate(time.Hour), sign)
+		addToRollup(daily, record, time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), sign)
+	}
+
+	if len(hourly) > 0 {
+		var rows []models.HourlyRollup
+		for _, rollup := range sortRollups(hourly) {
+			rows = append(rows, models.HourlyRollup{Rollup: rollup})
+		}
+		if err := upsertRollups(tx, "hourly_rollups", &rows); err != nil {
+			return err
+		}
+	}
+	if len(daily) > 0 {
+		var rows []models.DailyRollup
+		for _, rollup := range sortRollups(daily) {
+			rows = append(rows, models.DailyRollup{Rollup: rollup})
+		}
+		if err := upsertRollups(tx, "daily_rollups", &rows); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortRollups returns the rollups in the order of their key, so that
+// concurrent transactions lock the rows in the same order and can't deadlock.
+func sortRollups(rollups map[rollupKey]*models.Rollup) []models.Rollup {
+	sorted := make([]models.Rollup, 0, len(rollups))
+	for _, rollup := range rollups {
+		sorted = append(sorted, *rollup)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.SpyID != b.SpyID {
+			return a.SpyID < b.SpyID
+		}
+		if a.EventType != b.EventType {
+			return a.EventType < b.EventType
+		}
+		return a.Bucket.Before(b.Bucket)
+	})
+
+	return sorted
+}
+
+func addToRollup(rollups map[rollupKey]*models.Rollup, record models.Record, bucket time.Time, sign int64) {
+	key := rollupKey{spyId: record.SpyID, eventType: record.EventType, bucket: bucket}
+	rollup, ok := rollups[key]
+	if !ok {
+		rollup = &models.Rollup{SpyID: record.SpyID, EventType: record.EventType, Bucket: bucket}
+		rollups[key] = rollup
+	}
+
+	rollup.Total += sign
+	if !record.Suspicious {
+		rollup.Human += sign
+		if record.Visit == models.VisitFirst {
+			rollup.Unique += sign
+		}
+	}
+}
+
+func upsertRollups(tx *gorm.DB, table string, rows any) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "spy_id"}, {Name: "event_type"}, {Name: "bucket"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"total":   gorm.Expr(table + ".total + excluded.total"),
+			"human":   gorm.Expr(table + ".human + excluded.human"),
+			"uniques": gorm.Expr(table + ".uniques + excluded.uniques"),
+		}),
+	}).Create(rows).Error
+}
+
+// RebuildRollups recomputes the rollups from the records.
+func RebuildRollups() error {
+	if err := database.RebuildRollups(); err != nil {
+		return ServiceError{
+			Code:    500,
+			Message: "Error while rebuilding rollups: " + err.Error(),
+		}
+	}
+
+	return nil
+}
+
+// statsRange returns the rollup table and the bounds of the stats requested,
+// the last 30 days (or 2 days by hour) by default.
+func statsRange(req requestmodels.StatsRequest) (string, time.Time, time.Time, error) {
+	table, maxRange, defaultRange := "daily_rollups", maxDailyStatsRange, 30*24*time.Hour
+	if req.Period == "hour" {
+		table, maxRange, defaultRange = "hourly_rollups", maxHourlyStatsRange, 48*time.Hour
+	}
+
+	to := time.Now()
+	if req.To != "" {
+		to, _ = time.Parse(time.RFC3339, req.To)
+	}
+	from := to.Add(-defaultRange)
+	if req.From != "" {
+		from, _ = time.Parse(time.RFC3339, req.From)
+	}
+
+	if !from.Before(to) {
+		return "", time.Time{}, time.Time{}, ServiceError{
+			Code:    400,
+			Message: "The start of the stats must be before their end",
+		}
+	}
+	if to.Sub(from) > maxRange {
+		return "", time.Time{}, time.Time{}, ServiceError{
+			Code:    400,
+			Message: fmt.Sprintf("The stats can't span more than %d days by %s", int(maxRange.Hours()/24), req.Period),
+		}
+	}
+
+	return table, from, to, nil
+}
+
+func statsEventType(req requestmodels.StatsRequest) string {
+	if req.Type != "" {
+		return req.Type
+	}
+	return models.EventOpen
+}
+
+// GetSpyStats returns the counts of the records of a spy per hour or day.
+func GetSpyStats(spyId string, req requestmodels.StatsRequest, userId uint) ([]models.Rollup, error) {
+	spy, err := getOwnedSpy(spyId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	table, from, to, err := statsRange(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var rollups []models.Rollup
+	err = database.Db.Table(table).
+		Where("spy_id = ? AND event_type = ? AND bucket >= ? AND bucket < ?", spy.ID, statsEventType(req), from, to).
+		Order("bucket").
+		Find(&rollups).Error
+	if err != nil {
+		return nil, ServiceError{
+			Code:    500,
+			Message: "Error while retrieving stats: " + err.Error(),
+		}
+	}
+
+	return rollups, nil
+}
+
+// GetAllSpyStats returns the counts of the records of each spy of the user
+// over the period.
+func GetAllSpyStats(req requestmodels.StatsRequest, userId uint) ([]SpyStats, error) {
+	table, from, to, err := statsRange(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []SpyStats
+	err = database.Db.Model(&models.Spy{}).
+		Select("spies.id AS spy_id, spies.name, COALESCE(SUM(r.total), 0) AS total, COALESCE(SUM(r.human), 0) AS human, COALESCE(SUM(r.uniques), 0) AS uniques").
+		Joins("LEFT JOIN "+table+" r ON r.spy_id = spies.id AND r.event_type = ? AND r.bucket >= ? AND r.bucket < ?", statsEventType(req), from, to).
+		Where("spies.user_id = ?", userId).
+		Group("spies.id").
+		Order("spies.id").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, ServiceError{
+			Code:    500,
+			Message: "Error while retrieving stats: " + err.Error(),
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/models"
+)
+
+// Query parameters of a signed pixel url. Arbitrary key/values are prefixed
+// with paramPrefix, every other parameter is ignored and left unsigned.
+const (
+	paramRecipient = "r"
+	paramRcptToken = "rt" // token of an attached recipient
+	paramCampaign  = "c"
+	paramSentAt    = "t"
+	paramPrefix    = "m_"
+	paramSignature = "sig"
+)
+
+// PixelParams are the per-recipient fields carried by a pixel url.
+type PixelParams struct {
+	Recipient string
+	RcptToken string
+	Campaign  string
+	SentAt    *time.Time
+	Params    map[string]string
+	Status    string
+}
+
+func isSignedParam(key string) bool {
+	return key == paramRecipient || key == paramRcptToken || key == paramCampaign || key == paramSentAt || strings.HasPrefix(key, paramPrefix)
+}
+
+// signPixelParams computes the signature of the signed parameters of a pixel
+// url, tied to the spy token so a signature can't be replayed on another spy.
+func signPixelParams(token string, values url.Values) string {
+	signed := url.Values{}
+	for key, value := range values {
+		if isSignedParam(key) {
+			signed[key] = value
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(os.Getenv("PIXEL_SECRET")))
+	mac.Write([]byte(token + "\n" + signed.Encode()))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parsePixelParams extracts the per-recipient fields from the query of a
+// pixel url and checks their signature. Fields that are unsigned or tampered
+// with are kept but flagged as invalid.
+func parsePixelParams(token string, query map[string]string) PixelParams {
+	values := url.Values{}
+	for key, value := range query {
+		if isSignedParam(key) {
+			values.Set(key, value)
+		}
+	}
+
+	params := PixelParams{Status: models.SignatureNone}
+	if len(values) == 0 {
+		return params
+	}
+
+	params.Recipient = values.Get(paramRecipient)
+	params.RcptToken = values.Get(paramRcptToken)
+	params.Campaign = values.Get(paramCampaign)
+	if ts, err := strconv.ParseInt(values.Get(paramSentAt), 10, 64); err == nil {
+		sentAt := time.Unix(ts, 0)
+		params.SentAt = &sentAt
+	}
+	for key := range values {
+		if strings.HasPrefix(key, paramPrefix) {
+			if params.Params == nil {
+				params.Params = make(map[string]string)
+			}
+			params.Params[strings.TrimPrefix(key, paramPrefix)] = values.Get(key)
+		}
+	}
+
+	expected := signPixelParams(token, values)
+	if hmac.Equal([]byte(expected), []byte(query[paramSignature])) {
+		params.Status = models.SignatureValid
+	} else {
+		params.Status = models.SignatureInvalid
+	}
+
+	return params
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/models"
+)
+
+const testToken = "0123456789abcdef0123456789abcdef"
+
+// signedQuery returns the query of a pixel url of the token carrying the
+// values, signed.
+func signedQuery(token string, values url.Values) map[string]string {
+	query := make(map[string]string)
+	for key := range values {
+		query[key] = values.Get(key)
+	}
+	query[paramSignature] = signPixelParams(token, values)
+	return query
+}
+
+func TestParsePixelParams(t *testing.T) {
+	t.Setenv("PIXEL_SECRET", "a-pixel-secret-long-enough-for-the-tests")
+
+	values := url.Values{
+		paramRecipient:        {"jane@example.com"},
+		paramCampaign:         {"launch"},
+		paramSentAt:           {"1700000000"},
+		paramPrefix + "order": {"42"},
+	}
+	sentAt := time.Unix(1700000000, 0)
+	signed := PixelParams{
+		Recipient: "jane@example.com",
+		Campaign:  "launch",
+		SentAt:    &sentAt,
+		Params:    map[string]string{"order": "42"},
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		query  func() map[string]string
+		want   PixelParams
+		status string
+	}{
+		{
+			name:   "no fields",
+			token:  testToken,
+			query:  func() map[string]string { return map[string]string{"utm_source": "mail"} },
+			want:   PixelParams{},
+			status: models.SignatureNone,
+		},
+		{
+			name:   "signed",
+			token:  testToken,
+			query:  func() map[string]string { return signedQuery(testToken, values) },
+			want:   signed,
+			status: models.SignatureValid,
+		},
+		{
+			name:  "unsigned parameter added",
+			token: testToken,
+			query: func() map[string]string {
+				query := signedQuery(testToken, values)
+				query["utm_source"] = "mail"
+				return query
+			},
+			want:   signed,
+			status: models.SignatureValid,
+		},
+		{
+			name:  "tampered with",
+			token: testToken,
+			query: func() map[string]string {
+				query := signedQuery(testToken, values)
+				query[paramRecipient] = "john@example.com"
+				return query
+			},
+			want: PixelParams{
+				Recipient: "john@example.com",
+				Campaign:  "launch",
+				SentAt:    &sentAt,
+				Params:    map[string]string{"order": "42"},
+			},
+			status: models.SignatureInvalid,
+		},
+		{
+			name:  "signed field added",
+			token: testToken,
+			query: func() map[string]string {
+				query := signedQuery(testToken, values)
+				query[paramPrefix+"coupon"] = "free"
+				return query
+			},
+			want: PixelParams{
+				Recipient: "jane@example.com",
+				Campaign:  "launch",
+				SentAt:    &sentAt,
+				Params:    map[string]string{"order": "42", "coupon": "free"},
+			},
+			status: models.SignatureInvalid,
+		},
+		{
+			name:   "signed for another spy",
+			token:  testToken,
+			query:  func() map[string]string { return signedQuery("another-token", values) },
+			want:   signed,
+			status: models.SignatureInvalid,
+		},
+		{
+			name:  "unsigned",
+			token: testToken,
+			query: func() map[string]string {
+				query := signedQuery(testToken, values)
+				delete(query, paramSignature)
+				return query
+			},
+			want:   signed,
+			status: models.SignatureInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parsePixelParams(tt.token, tt.query())
+
+			want := tt.want
+			want.Status = tt.status
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("parsePixelParams() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSignPixelParamsSecret(t *testing.T) {
+	values := url.Values{paramRecipient: {"jane@example.com"}}
+
+	t.Setenv("PIXEL_SECRET", "a-pixel-secret-long-enough-for-the-tests")
+	signature := signPixelParams(testToken, values)
+
+	t.Setenv("PIXEL_SECRET", "another-pixel-secret-long-enough-for-tests")
+	if signPixelParams(testToken, values) == signature {
+		t.Error("signPixelParams() doesn't depend on PIXEL_SECRET")
+	}
+}
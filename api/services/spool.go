@@ -0,0 +1,269 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/env"
+	"github.com/ZiplEix/pixel-espion/models"
+	"github.com/ZiplEix/pixel-espion/spool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const defaultSpoolReplayInterval = 30 * time.Second
+
+// Kind of spool entry.
+const (
+	spoolRecord = "record" // an enriched record that couldn't be stored
+	spoolPixel  = "pixel"  // a pixel hit whose spy couldn't be looked up
+	spoolBeacon = "beacon" // a beacon whose spy couldn't be looked up
+)
+
+// spoolEntry is what is kept on the disk while the database is down. Its id
+// becomes the SpoolID of the record, which is unique along with the record
+// time, so that replaying an entry twice never stores it twice. The record of
+// a hit whose spy couldn't be looked up is completed on replay.
+type spoolEntry struct {
+	Id        string
+	Kind      string
+	Time      time.Time
+	Record    *models.Record `json:",omitempty"`
+	Token     string         `json:",omitempty"`
+	RcptToken string         `json:",omitempty"`
+}
+
+// placeholderSpy is served while the spy of a spooled hit can't be looked up.
+var placeholderSpy = models.Spy{Color: "#000000", Width: 1, Height: 1, Alpha: 0}
+
+// appendSpool writes the entries to the spool if the database is down, and
+// returns whether they were.
+func appendSpool(entries []spoolEntry) bool {
+	if !spool.Enabled() || database.Ping() == nil {
+		return false
+	}
+
+	lines := make([][]byte, len(entries))
+	for i := range entries {
+		id, err := models.NewToken()
+		if err != nil {
+			fmt.Printf("Error while spooling: %v\n", err)
+			return false
+		}
+		entries[i].Id = id
+		if entries[i].Record != nil {
+			entries[i].Record.SpoolID = &id
+		}
+
+		lines[i], err = json.Marshal(entries[i])
+		if err != nil {
+			fmt.Printf("Error while spooling: %v\n", err)
+			return false
+		}
+	}
+
+	if err := spool.Append(lines...); err != nil {
+		fmt.Printf("Error while spooling: %v\n", err)
+		return false
+	}
+
+	return true
+}
+
+// spoolRecords keeps enriched records that couldn't be stored.
+func spoolRecords(records []models.Record) bool {
+	entries := make([]spoolEntry, len(records))
+	for i := range records {
+		record := records[i]
+		record.ID = 0
+		entries[i] = spoolEntry{Kind: spoolRecord, Time: record.Time, Record: &record, RcptToken: record.RcptToken}
+	}
+
+	return appendSpool(entries)
+}
+
+// spoolHit keeps the record of a hit whose spy couldn't be looked up, enriched
+// with what doesn't depend on the spy. The IP mode of the spy being unknown,
+// the addresses are dropped before the record reaches the disk.
+func spoolHit(entry spoolEntry) bool {
+	record := entry.Record
+	enrichUserAgent(record, models.Spy{})
+	enrichGeo(record, models.Spy{})
+	enrichProxy(record, models.Spy{})
+	// without the spy, the bursts aren't counted and the threshold is applied
+	// on replay
+	enrichBot(record, models.Spy{})
+	// keyed as if the addresses weren't kept, the IP mode being unknown
+	enrichVisitorKey(record, models.IpNone)
+	enrichAnonymize(record, models.Spy{IpMode: models.IpNone})
+
+	entry.Time = record.Time
+	return appendSpool([]spoolEntry{entry})
+}
+
+// spoolPixelHit keeps a pixel hit whose spy couldn't be looked up.
+func spoolPixelHit(token string, req RequestContext, prefetch bool) bool {
+	record := pixelHit(token, req, prefetch)
+	return spoolHit(spoolEntry{Kind: spoolPixel, Token: token, RcptToken: record.RcptToken, Record: &record})
+}
+
+// spoolBeaconHit keeps a beacon whose spy couldn't be looked up.
+func spoolBeaconHit(token string, req RequestContext, payload map[string]any) bool {
+	record := newRecord(0, models.EventBeacon, req)
+	record.Payload = payload
+	return spoolHit(spoolEntry{Kind: spoolBeacon, Token: token, Record: &record})
+}
+
+// StartSpoolReplay replays the spool in the background, every
+// SPOOL_REPLAY_INTERVAL (30s by default) while there is something to replay.
+func StartSpoolReplay() error {
+	interval, err := env.Duration("SPOOL_REPLAY_INTERVAL", defaultSpoolReplayInterval)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			if err := database.Ping(); err != nil {
+				continue
+			}
+			if err := spool.Rotate(); err != nil {
+				fmt.Printf("Error while rotating the spool: %v\n", err)
+				continue
+			}
+
+			replayed, err := ReplaySpool()
+			if replayed > 0 {
+				fmt.Printf("%d spooled records replayed\n", replayed)
+			}
+			if err != nil {
+				fmt.Printf("Error while replaying the spool: %v\n", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// ReplaySpool stores the entries of the rotated spool files, oldest first,
+// and removes the files once done. It returns how many records were stored,
+// the entries already replayed being skipped.
+func ReplaySpool() (int, error) {
+	paths, err := spool.Rotated()
+	if err != nil {
+		return 0, ServiceError{
+			Code:    500,
+			Message: "Error while listing the spool: " + err.Error(),
+		}
+	}
+
+	replayed := 0
+	for _, path := range paths {
+		err := spool.Read(path, func(line []byte) error {
+			var entry spoolEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				// most likely the last line of a crash while writing
+				fmt.Printf("Skipping invalid spool entry of '%s': %v\n", path, err)
+				return nil
+			}
+
+			stored, err := replayEntry(entry)
+			if stored {
+				replayed++
+			}
+			return err
+		})
+		if err != nil {
+			return replayed, ServiceError{
+				Code:    500,
+				Message: fmt.Sprintf("Error while replaying '%s': %v", path, err),
+			}
+		}
+
+		if err := spool.Remove(path); err != nil {
+			return replayed, ServiceError{
+				Code:    500,
+				Message: "Error while removing a replayed spool file: " + err.Error(),
+			}
+		}
+	}
+
+	return replayed, nil
+}
+
+// replayEntry stores the record of a spool entry, unless it was already, and
+// returns whether it did. The records of the hits are completed with their spy
+// the way the tracking endpoints would have.
+func replayEntry(entry spoolEntry) (bool, error) {
+	var record models.Record
+
+	switch entry.Kind {
+	case spoolRecord:
+		if entry.Record == nil {
+			return false, nil
+		}
+		record = *entry.Record
+		record.RcptToken = entry.RcptToken
+		if record.RecipientID == nil {
+			if err := linkRecipient(&record); err != nil {
+				return false, err
+			}
+		}
+	case spoolPixel, spoolBeacon:
+		if entry.Record == nil {
+			return false, nil
+		}
+
+		spy, err := spyByToken(entry.Token)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		record = *entry.Record
+		record.SpyID = spy.ID
+		record.RcptToken = entry.RcptToken
+		if err := linkRecipient(&record); err != nil {
+			return false, err
+		}
+		record.Suspicious = record.BotScore > botThreshold(spy)
+		enrichVisit(&record, spy)
+	default:
+		return false, nil
+	}
+
+	record.SpoolID = &entry.Id
+
+	stored := false
+	err := database.Db.Transaction(func(tx *gorm.DB) error {
+		result := insertSpooled(tx, &record)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		stored = true
+		return updateRollups(tx, []models.Record{record}, 1)
+	})
+	if err != nil || !stored {
+		return false, err
+	}
+
+	lookupHostname(record)
+
+	return true, nil
+}
+
+// insertSpooled inserts the record of a spool entry, unless the entry was
+// replayed already, the unique spool id and time of the record conflicting
+// then.
+func insertSpooled(tx *gorm.DB, record *models.Record) *gorm.DB {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "spool_id"}, {Name: "time"}},
+		DoNothing: true,
+	}).Create(record)
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dryRun returns a session that builds the SQL of the statements without
+// connecting to a database.
+func dryRun(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestInsertSpooled(t *testing.T) {
+	id := "0123456789abcdef0123456789abcdef"
+	record := models.Record{SpyID: 1, Time: time.Now(), SpoolID: &id}
+
+	result := insertSpooled(dryRun(t), &record)
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	sql := result.Statement.SQL.String()
+	if !strings.HasPrefix(sql, `INSERT INTO "records"`) {
+		t.Fatalf("insertSpooled() = %q, want an insert", sql)
+	}
+	if !strings.HasSuffix(sql, `ON CONFLICT ("spool_id","time") DO NOTHING RETURNING "id"`) {
+		t.Errorf("insertSpooled() = %q, want the replays of an entry to conflict on its spool id and time", sql)
+	}
+}
+
+func TestSpoolEntryJSON(t *testing.T) {
+	id := "0123456789abcdef0123456789abcdef"
+	sentAt := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+	entry := spoolEntry{
+		Id:        id,
+		Kind:      spoolPixel,
+		Time:      time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Token:     testToken,
+		RcptToken: "rcpt",
+		Record: &models.Record{
+			Time:      time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+			SpoolID:   &id,
+			Recipient: "jane@example.com",
+			SentAt:    &sentAt,
+			BotScore:  40,
+			RcptToken: "rcpt",
+		},
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsRune(string(data), '\n') {
+		t.Fatalf("spool entry %q spans several lines", data)
+	}
+
+	var got spoolEntry
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != id || got.Kind != spoolPixel || got.Token != testToken || got.RcptToken != "rcpt" || !got.Time.Equal(entry.Time) {
+		t.Errorf("spool entry = %+v, want %+v", got, entry)
+	}
+	if got.Record == nil || got.Record.SpoolID == nil || *got.Record.SpoolID != id {
+		t.Fatalf("record = %+v, want its spool id kept", got.Record)
+	}
+	if got.Record.Recipient != "jane@example.com" || got.Record.BotScore != 40 || !got.Record.SentAt.Equal(sentAt) {
+		t.Errorf("record = %+v, want %+v", got.Record, entry.Record)
+	}
+}
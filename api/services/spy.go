@@ -1,66 +1,170 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/ZiplEix/pixel-espion/database"
 	"github.com/ZiplEix/pixel-espion/models"
 	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+	"github.com/ZiplEix/pixel-espion/storage"
 	"github.com/sanity-io/litter"
+	"gorm.io/gorm"
 )
 
-func Pixel1(spyId string, clientIp string) (string, error) {
-	var spy models.Spy
-	if err := database.Db.First(&spy, spyId).Error; err != nil {
-		return "", ServiceError{
+func Pixel1(token string, req RequestContext, format PixelFormat, prefetch bool) ([]byte, string, error) {
+	spy, err := spyByToken(token)
+	if err != nil {
+		// the database is down: the hit is replayed once it is back
+		if !errors.Is(err, gorm.ErrRecordNotFound) && spoolPixelHit(token, req, prefetch) {
+			return servePixel(placeholderSpy, format)
+		}
+
+		return nil, "", ServiceError{
 			Code:    404,
 			Message: "Spy not found: " + err.Error(),
 		}
 	}
 
-	record := models.Record{
-		Ip:    clientIp,
-		SpyID: spy.ID,
-		Time:  time.Now(),
-	}
+	record := pixelRecord(spy, token, req, prefetch)
 
-	if err := database.Db.Create(&record).Error; err != nil {
-		return "", ServiceError{
+	if err := createRecord(&record, spy); err != nil {
+		return nil, "", ServiceError{
 			Code:    500,
 			Message: "Error while creating record: " + err.Error(),
 		}
 	}
 
-	fmt.Printf("Spy '%s' has been visited by '%s'\n", spy.Name, clientIp)
+	fmt.Printf("Spy '%s' has been visited by '%s'\n", spy.Name, req.Ip)
 	litter.Dump(record)
 
-	imagePath := "pixel/spy.png"
+	return servePixel(spy, format)
+}
+
+// pixelRecord returns the record of a pixel hit, with the per-recipient
+// fields of the pixel url.
+func pixelRecord(spy models.Spy, token string, req RequestContext, prefetch bool) models.Record {
+	record := pixelHit(token, req, prefetch)
+	record.SpyID = spy.ID
+
+	return record
+}
+
+// pixelHit returns the record of a pixel hit before its spy is known, with the
+// per-recipient fields of the pixel url.
+func pixelHit(token string, req RequestContext, prefetch bool) models.Record {
+	params := parsePixelParams(token, req.Query)
+
+	eventType := models.EventOpen
+	if prefetch {
+		eventType = models.EventPrefetch
+	}
+
+	record := newRecord(0, eventType, req)
+	record.Recipient = params.Recipient
+	record.RcptToken = params.RcptToken
+	record.Campaign = params.Campaign
+	record.SentAt = params.SentAt
+	record.Params = params.Params
+	record.SignatureStatus = params.Status
+
+	return record
+}
 
-	return imagePath, nil
+func servePixel(spy models.Spy, format PixelFormat) ([]byte, string, error) {
+	img, contentType, err := getPixel(spy, format)
+	if err != nil {
+		return nil, "", ServiceError{
+			Code:    500,
+			Message: "Error while rendering pixel: " + err.Error(),
+		}
+	}
+
+	return img, contentType, nil
+}
+
+// applySpySettings copies the optional settings of the request on the spy,
+// falling back to an opaque 1x1 pixel and the default bot threshold and dedup
+// window.
+func applySpySettings(spy *models.Spy, req requestmodels.NewSpyRequest) {
+	spy.Width = 1
+	if req.Width != 0 {
+		spy.Width = req.Width
+	}
+	spy.Height = 1
+	if req.Height != 0 {
+		spy.Height = req.Height
+	}
+	spy.Alpha = 255
+	if req.Alpha != nil {
+		spy.Alpha = *req.Alpha
+	}
+	spy.BotThreshold = defaultBotThreshold
+	if req.BotThreshold != nil {
+		spy.BotThreshold = *req.BotThreshold
+	}
+	spy.IpMode = req.IpMode
+	spy.DedupWindow = defaultDedupWindow
+	if req.DedupWindow != 0 {
+		spy.DedupWindow = req.DedupWindow
+	}
+}
+
+// applySpyUpdate copies the fields set in the request on the spy, the others
+// keeping their stored value.
+func applySpyUpdate(spy *models.Spy, req requestmodels.UpdateSpyRequest) {
+	if req.Name != nil {
+		spy.Name = *req.Name
+	}
+	if req.Color != nil {
+		spy.Color = *req.Color
+	}
+	if req.Width != nil {
+		spy.Width = *req.Width
+	}
+	if req.Height != nil {
+		spy.Height = *req.Height
+	}
+	if req.Alpha != nil {
+		spy.Alpha = *req.Alpha
+	}
+	if req.BotThreshold != nil {
+		spy.BotThreshold = *req.BotThreshold
+	}
+	if req.IpMode != nil {
+		spy.IpMode = *req.IpMode
+	}
+	if req.DedupWindow != nil {
+		spy.DedupWindow = *req.DedupWindow
+	}
 }
 
-func NewSpy(req requestmodels.NewSpyRequest, userId uint) (uint, error) {
+func NewSpy(req requestmodels.NewSpyRequest, userId uint) (models.Spy, error) {
 	spy := models.Spy{
 		Name:   req.Name,
 		Color:  req.Color,
 		UserId: userId,
 	}
+	applySpySettings(&spy, req)
 
 	if err := database.Db.Create(&spy).Error; err != nil {
-		return 0, ServiceError{
+		return models.Spy{}, ServiceError{
 			Code:    500,
 			Message: "Error while creating spy: " + err.Error(),
 		}
 	}
 
-	return spy.ID, nil
+	return spy, nil
 }
 
 func GetAllSpies(userId uint) ([]models.Spy, error) {
 	var spies []models.Spy
 
-	if err := database.Db.Where("user_id = ?", userId).Find(&spies).Error; err != nil {
+	if err := withOpenCounts(database.Db).Where("user_id = ?", userId).Find(&spies).Error; err != nil {
 		return nil, ServiceError{
 			Code:    500,
 			Message: "Error while fetching spies: " + err.Error(),
@@ -70,23 +174,59 @@ func GetAllSpies(userId uint) ([]models.Spy, error) {
 	return spies, nil
 }
 
-func GetSpy(spyId string) (*models.Spy, error) {
+// getOwnedSpy returns the spy if the user is its owner.
+func getOwnedSpy(spyId string, userId uint) (models.Spy, error) {
 	var spy models.Spy
 
-	if err := database.Db.First(&spy, "id = ?", spyId).Error; err != nil {
+	if err := database.Db.First(&spy, spyId).Error; err != nil {
+		return models.Spy{}, ServiceError{
+			Code:    404,
+			Message: fmt.Sprintf("Spy with ID %s not found", spyId),
+		}
+	}
+
+	if spy.UserId != userId {
+		return models.Spy{}, ServiceError{
+			Code:    403,
+			Message: "Unauthorized to access this spy",
+		}
+	}
+
+	return spy, nil
+}
+
+func GetSpy(spyId string, userId uint) (*models.Spy, error) {
+	var spy models.Spy
+
+	if err := withOpenCounts(database.Db).First(&spy, "id = ?", spyId).Error; err != nil {
 		return nil, ServiceError{
 			Code:    404,
 			Message: "Spy not found: " + err.Error(),
 		}
 	}
 
+	// the token of the spy is enough to forge its hits
+	if spy.UserId != userId {
+		return nil, ServiceError{
+			Code:    403,
+			Message: "Unauthorized to access this spy",
+		}
+	}
+
 	return &spy, nil
 }
 
-func GetSpyRecords(spyId string) ([]models.Record, error) {
+func GetSpyRecords(spyId string, userId uint, filter requestmodels.RecordFilter) ([]models.Record, error) {
 	var records []models.Record
 
-	if err := database.Db.Where("spy_id = ?", spyId).Find(&records).Error; err != nil {
+	spy, err := getOwnedSpy(spyId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	query := filterRecords(database.Db.Where("records.spy_id = ?", spy.ID), filter)
+
+	if err := query.Find(&records).Error; err != nil {
 		return nil, ServiceError{
 			Code:    500,
 			Message: "Error while retrieving records: " + err.Error(),
@@ -96,10 +236,36 @@ func GetSpyRecords(spyId string) ([]models.Record, error) {
 	return records, nil
 }
 
-func GetAllRecords(userId uint) ([]models.Record, error) {
+// GroupSpyRecords counts the records of a spy matching the filter, grouped by
+// the column of filter.GroupBy. Suspicious records are never counted.
+func GroupSpyRecords(spyId string, filter requestmodels.RecordFilter) ([]RecordGroup, error) {
+	var groups []RecordGroup
+
+	column := recordGroupColumns[filter.GroupBy]
+	filter.ExcludeSuspicious = true
+	query := filterRecords(database.Db.Model(&models.Record{}).Where("records.spy_id = ?", spyId), filter)
+
+	err := query.
+		Select(column + " AS value, COUNT(*) AS count").
+		Group(column).
+		Order("count DESC").
+		Scan(&groups).Error
+	if err != nil {
+		return nil, ServiceError{
+			Code:    500,
+			Message: "Error while grouping records: " + err.Error(),
+		}
+	}
+
+	return groups, nil
+}
+
+func GetAllRecords(userId uint, filter requestmodels.RecordFilter) ([]models.Record, error) {
 	var records []models.Record
 
-	if err := database.Db.Joins("JOIN spies ON spies.id = records.spy_id").Where("spies.user_id = ?", userId).Find(&records).Error; err != nil {
+	query := filterRecords(database.Db.Joins("JOIN spies ON spies.id = records.spy_id").Where("spies.user_id = ?", userId), filter)
+
+	if err := query.Find(&records).Error; err != nil {
 		return nil, ServiceError{
 			Code:    500,
 			Message: "Error while retrieving user records: " + err.Error(),
@@ -109,7 +275,7 @@ func GetAllRecords(userId uint) ([]models.Record, error) {
 	return records, nil
 }
 
-func UpdateSpy(spyId string, req requestmodels.NewSpyRequest, userId uint) error {
+func UpdateSpy(spyId string, req requestmodels.UpdateSpyRequest, userId uint) error {
 	var spy models.Spy
 
 	if err := database.Db.First(&spy, spyId).Error; err != nil {
@@ -127,8 +293,7 @@ func UpdateSpy(spyId string, req requestmodels.NewSpyRequest, userId uint) error
 		}
 	}
 
-	spy.Name = req.Name
-	spy.Color = req.Color
+	applySpyUpdate(&spy, req)
 
 	if err := database.Db.Save(&spy).Error; err != nil {
 		return ServiceError{
@@ -137,9 +302,97 @@ func UpdateSpy(spyId string, req requestmodels.NewSpyRequest, userId uint) error
 		}
 	}
 
+	invalidatePixel(spy.ID)
+	invalidateSpy(spy)
+
 	return nil
 }
 
+func RotateSpyToken(spyId string, userId uint) (string, error) {
+	var spy models.Spy
+
+	if err := database.Db.First(&spy, spyId).Error; err != nil {
+		return "", ServiceError{
+			Code:    404,
+			Message: fmt.Sprintf("Spy with ID %s not found", spyId),
+		}
+	}
+
+	if spy.UserId != userId {
+		return "", ServiceError{
+			Code:    403,
+			Message: "Unauthorized to rotate the token of this spy",
+		}
+	}
+
+	token, err := models.NewToken()
+	if err != nil {
+		return "", ServiceError{
+			Code:    500,
+			Message: "Error while generating token: " + err.Error(),
+		}
+	}
+
+	if err := database.Db.Model(&spy).Update("token", token).Error; err != nil {
+		return "", ServiceError{
+			Code:    500,
+			Message: "Error while rotating spy token: " + err.Error(),
+		}
+	}
+
+	invalidateSpy(spy)
+
+	return token, nil
+}
+
+func SignedPixelUrl(spyId string, req requestmodels.SignedUrlRequest, userId uint) (string, error) {
+	var spy models.Spy
+
+	if err := database.Db.First(&spy, spyId).Error; err != nil {
+		return "", ServiceError{
+			Code:    404,
+			Message: fmt.Sprintf("Spy with ID %s not found", spyId),
+		}
+	}
+
+	if spy.UserId != userId {
+		return "", ServiceError{
+			Code:    403,
+			Message: "Unauthorized to sign urls for this spy",
+		}
+	}
+
+	sentAt := time.Now()
+	if req.SentAt != nil {
+		sentAt = *req.SentAt
+	}
+
+	values := url.Values{}
+	values.Set(paramSentAt, strconv.FormatInt(sentAt.Unix(), 10))
+	if req.Recipient != "" {
+		values.Set(paramRecipient, req.Recipient)
+	}
+	if req.Campaign != "" {
+		values.Set(paramCampaign, req.Campaign)
+	}
+	for key, value := range req.Params {
+		values.Set(paramPrefix+key, value)
+	}
+
+	return signedPixelUrl(spy, values, req.Format), nil
+}
+
+// signedPixelUrl returns the pixel url of the spy in the given format (PNG by
+// default) carrying the signed values.
+func signedPixelUrl(spy models.Spy, values url.Values, format string) string {
+	if format == "" {
+		format = string(PixelPNG)
+	}
+	values.Set(paramSignature, signPixelParams(spy.Token, values))
+
+	return fmt.Sprintf("%s/p/%s.%s?%s", os.Getenv("TRACKING_URL"), spy.Token, format, values.Encode())
+}
+
 func DeleteSpy(spyId string, userId uint) error {
 	var spy models.Spy
 
@@ -158,6 +411,16 @@ func DeleteSpy(spyId string, userId uint) error {
 		}
 	}
 
+	// the pixel falls back to the rendered one if the spy outlives its image
+	if spy.Image != "" {
+		if err := storage.Store.Delete(spy.Image); err != nil {
+			return ServiceError{
+				Code:    500,
+				Message: "Error while deleting image: " + err.Error(),
+			}
+		}
+	}
+
 	if err := database.Db.Delete(&spy).Error; err != nil {
 		return ServiceError{
 			Code:    500,
@@ -165,6 +428,9 @@ func DeleteSpy(spyId string, userId uint) error {
 		}
 	}
 
+	invalidatePixel(spy.ID)
+	invalidateSpy(spy)
+
 	return nil
 }
 
@@ -196,7 +462,13 @@ func DeleteRecord(recordId string, userId uint) error {
 	}
 
 	// Supprimer le record
-	if err := database.Db.Delete(&record).Error; err != nil {
+	err := database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&record).Error; err != nil {
+			return err
+		}
+		return updateRollups(tx, []models.Record{record}, -1)
+	})
+	if err != nil {
 		return ServiceError{
 			Code:    500,
 			Message: "Error while deleting record: " + err.Error(),
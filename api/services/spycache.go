@@ -0,0 +1,209 @@
+package services
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/env"
+	"github.com/ZiplEix/pixel-espion/models"
+	"gorm.io/gorm"
+)
+
+// Defaults of the SPY_CACHE_* env vars.
+const (
+	defaultSpyCacheSize        = 10000
+	defaultSpyCacheTTL         = 5 * time.Minute
+	defaultSpyCacheNegativeTTL = time.Minute
+)
+
+type spyCacheEntry struct {
+	token   string
+	spy     *models.Spy // nil when no spy has the token
+	expires time.Time
+}
+
+// spyCache keeps the spies looked up by token on the tracking endpoints, with
+// the IP mode of their owner, in least recently used order. Unknown tokens are remembered as
+// well so that guessing tokens doesn't hit the database every time. Entries
+// are dropped whenever the spy or its owner settings change.
+var spyCache struct {
+	sync.Mutex
+	enabled     bool
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	order       *list.List // of *spyCacheEntry, most recently used first
+	byToken     map[string]*list.Element
+	byId        map[uint]*list.Element
+	hits        uint64
+	misses      uint64
+}
+
+// SpyCacheStats are the counters of the spy cache since the start.
+type SpyCacheStats struct {
+	Size   int
+	Hits   uint64
+	Misses uint64
+}
+
+// SetupSpyCache enables the spy cache, configured by the env vars:
+//   - SPY_CACHE_SIZE: the most spies kept
+//   - SPY_CACHE_TTL: how long a spy is kept, such as "5m"
+//   - SPY_CACHE_NEGATIVE_TTL: how long an unknown token is remembered
+func SetupSpyCache() error {
+	fmt.Println("Setting up spy cache...")
+
+	size, err := env.Int("SPY_CACHE_SIZE", defaultSpyCacheSize)
+	if err != nil {
+		return err
+	}
+	ttl, err := env.Duration("SPY_CACHE_TTL", defaultSpyCacheTTL)
+	if err != nil {
+		return err
+	}
+	negativeTTL, err := env.Duration("SPY_CACHE_NEGATIVE_TTL", defaultSpyCacheNegativeTTL)
+	if err != nil {
+		return err
+	}
+
+	spyCache.Lock()
+	defer spyCache.Unlock()
+
+	spyCache.enabled = true
+	spyCache.size = size
+	spyCache.ttl = ttl
+	spyCache.negativeTTL = negativeTTL
+	spyCache.order = list.New()
+	spyCache.byToken = make(map[string]*list.Element)
+	spyCache.byId = make(map[uint]*list.Element)
+
+	return nil
+}
+
+// GetSpyCacheStats returns the counters of the spy cache.
+func GetSpyCacheStats() SpyCacheStats {
+	spyCache.Lock()
+	defer spyCache.Unlock()
+
+	stats := SpyCacheStats{Hits: spyCache.hits, Misses: spyCache.misses}
+	if spyCache.enabled {
+		stats.Size = spyCache.order.Len()
+	}
+
+	return stats
+}
+
+// spyByToken returns the spy having the token, with the IP mode of its owner.
+func spyByToken(token string) (models.Spy, error) {
+	spyCache.Lock()
+	if !spyCache.enabled {
+		spyCache.Unlock()
+		return loadSpy(token)
+	}
+	if elem, ok := spyCache.byToken[token]; ok {
+		entry := elem.Value.(*spyCacheEntry)
+		if time.Now().Before(entry.expires) {
+			spyCache.order.MoveToFront(elem)
+			spyCache.hits++
+			spyCache.Unlock()
+			if entry.spy == nil {
+				return models.Spy{}, gorm.ErrRecordNotFound
+			}
+			return *entry.spy, nil
+		}
+		removeSpyEntry(elem)
+	}
+	spyCache.misses++
+	spyCache.Unlock()
+
+	spy, err := loadSpy(token)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		// don't remember a database failure as an unknown token
+		return spy, err
+	}
+
+	entry := &spyCacheEntry{token: token, expires: time.Now().Add(spyCache.negativeTTL)}
+	if err == nil {
+		entry.spy = &spy
+		entry.expires = time.Now().Add(spyCache.ttl)
+	}
+
+	spyCache.Lock()
+	if elem, ok := spyCache.byToken[token]; ok {
+		removeSpyEntry(elem)
+	}
+	if entry.spy != nil {
+		if elem, ok := spyCache.byId[spy.ID]; ok {
+			removeSpyEntry(elem)
+		}
+	}
+	elem := spyCache.order.PushFront(entry)
+	spyCache.byToken[token] = elem
+	if entry.spy != nil {
+		spyCache.byId[spy.ID] = elem
+	}
+	for spyCache.order.Len() > spyCache.size {
+		removeSpyEntry(spyCache.order.Back())
+	}
+	spyCache.Unlock()
+
+	return spy, err
+}
+
+// loadSpy loads the spies missing from the cache.
+var loadSpy = loadSpyByToken
+
+// loadSpyByToken loads the spy having the token. Of its owner, only the IP
+// mode is loaded, the spy being cached for a while.
+func loadSpyByToken(token string) (models.Spy, error) {
+	var spy models.Spy
+	err := database.Db.Preload("User", func(tx *gorm.DB) *gorm.DB {
+		return tx.Select("id", "ip_mode")
+	}).Where("token = ?", token).First(&spy).Error
+	return spy, err
+}
+
+// removeSpyEntry drops an entry of the cache. The lock must be held.
+func removeSpyEntry(elem *list.Element) {
+	entry := spyCache.order.Remove(elem).(*spyCacheEntry)
+	delete(spyCache.byToken, entry.token)
+	if entry.spy != nil {
+		delete(spyCache.byId, entry.spy.ID)
+	}
+}
+
+// invalidateSpy drops the spy from the cache, along with the unknown token
+// entry of its current token.
+func invalidateSpy(spy models.Spy) {
+	spyCache.Lock()
+	defer spyCache.Unlock()
+
+	if !spyCache.enabled {
+		return
+	}
+	if elem, ok := spyCache.byId[spy.ID]; ok {
+		removeSpyEntry(elem)
+	}
+	if elem, ok := spyCache.byToken[spy.Token]; ok {
+		removeSpyEntry(elem)
+	}
+}
+
+// invalidateUserSpies drops the spies of the user from the cache.
+func invalidateUserSpies(userId uint) {
+	spyCache.Lock()
+	defer spyCache.Unlock()
+
+	if !spyCache.enabled {
+		return
+	}
+	for _, elem := range spyCache.byId {
+		if elem.Value.(*spyCacheEntry).spy.UserId == userId {
+			removeSpyEntry(elem)
+		}
+	}
+}
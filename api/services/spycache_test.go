@@ -0,0 +1,191 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/models"
+	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+	"gorm.io/gorm"
+)
+
+// fakeSpies stands for the spies table, counting the loads of each token.
+type fakeSpies struct {
+	spies map[string]models.Spy
+	loads map[string]int
+	err   error
+}
+
+// setupSpyCache enables the spy cache with the settings, loading the spies
+// from the returned fake table.
+func setupSpyCache(t *testing.T, size string, ttl string, negativeTTL string) *fakeSpies {
+	t.Helper()
+
+	fake := &fakeSpies{spies: make(map[string]models.Spy), loads: make(map[string]int)}
+	previous := loadSpy
+	loadSpy = func(token string) (models.Spy, error) {
+		fake.loads[token]++
+		if fake.err != nil {
+			return models.Spy{}, fake.err
+		}
+		spy, ok := fake.spies[token]
+		if !ok {
+			return models.Spy{}, gorm.ErrRecordNotFound
+		}
+		return spy, nil
+	}
+
+	t.Setenv("SPY_CACHE_SIZE", size)
+	t.Setenv("SPY_CACHE_TTL", ttl)
+	t.Setenv("SPY_CACHE_NEGATIVE_TTL", negativeTTL)
+	if err := SetupSpyCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		loadSpy = previous
+		spyCache.Lock()
+		spyCache.enabled = false
+		spyCache.hits = 0
+		spyCache.misses = 0
+		spyCache.Unlock()
+	})
+
+	return fake
+}
+
+func (f *fakeSpies) add(id uint, token string, userId uint) models.Spy {
+	spy := models.Spy{Token: token, Name: token, UserId: userId}
+	spy.ID = id
+	f.spies[token] = spy
+	return spy
+}
+
+// lookup looks the token up, and fails the test if the outcome isn't the
+// expected one.
+func lookup(t *testing.T, token string, wantName string) {
+	t.Helper()
+
+	spy, err := spyByToken(token)
+	if wantName == "" {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("spyByToken(%q) error = %v, want not found", token, err)
+		}
+		return
+	}
+	if err != nil || spy.Name != wantName {
+		t.Fatalf("spyByToken(%q) = %q, %v, want %q", token, spy.Name, err, wantName)
+	}
+}
+
+func TestSpyCacheHit(t *testing.T) {
+	fake := setupSpyCache(t, "", "", "")
+	fake.add(1, "a", 1)
+
+	for i := 0; i < 3; i++ {
+		lookup(t, "a", "a")
+		lookup(t, "unknown", "")
+	}
+	if fake.loads["a"] != 1 || fake.loads["unknown"] != 1 {
+		t.Errorf("loads = %v, want each token loaded once", fake.loads)
+	}
+	if stats := GetSpyCacheStats(); stats != (SpyCacheStats{Size: 2, Hits: 4, Misses: 2}) {
+		t.Errorf("GetSpyCacheStats() = %+v", stats)
+	}
+}
+
+func TestSpyCacheExpiry(t *testing.T) {
+	fake := setupSpyCache(t, "", "300ms", "50ms")
+	fake.add(1, "a", 1)
+
+	lookup(t, "a", "a")
+	lookup(t, "b", "")
+
+	// the unknown token expires first
+	time.Sleep(100 * time.Millisecond)
+	fake.add(2, "b", 1)
+	lookup(t, "a", "a")
+	lookup(t, "b", "b")
+	if fake.loads["a"] != 1 || fake.loads["b"] != 2 {
+		t.Errorf("loads = %v, want only the unknown token loaded again", fake.loads)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	lookup(t, "a", "a")
+	if fake.loads["a"] != 2 {
+		t.Errorf("loads = %v, want the spy loaded again after its TTL", fake.loads)
+	}
+}
+
+func TestSpyCacheEviction(t *testing.T) {
+	fake := setupSpyCache(t, "2", "", "")
+	fake.add(1, "a", 1)
+	fake.add(2, "b", 1)
+	fake.add(3, "c", 1)
+
+	lookup(t, "a", "a")
+	lookup(t, "b", "b")
+	// a is now the most recently used, so c evicts b
+	lookup(t, "a", "a")
+	lookup(t, "c", "c")
+
+	if stats := GetSpyCacheStats(); stats.Size != 2 {
+		t.Errorf("cache size = %d, want 2", stats.Size)
+	}
+	lookup(t, "a", "a")
+	lookup(t, "b", "b")
+	if fake.loads["a"] != 1 || fake.loads["b"] != 2 {
+		t.Errorf("loads = %v, want the least recently used spy evicted", fake.loads)
+	}
+}
+
+func TestSpyCacheDatabaseFailure(t *testing.T) {
+	fake := setupSpyCache(t, "", "", "")
+	fake.add(1, "a", 1)
+	fake.err = errors.New("connection refused")
+
+	if _, err := spyByToken("a"); err == nil || errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("spyByToken() error = %v, want the database failure", err)
+	}
+
+	fake.err = nil
+	lookup(t, "a", "a")
+	if fake.loads["a"] != 2 {
+		t.Errorf("loads = %v, want the failure left out of the cache", fake.loads)
+	}
+}
+
+func TestSpyCacheInvalidation(t *testing.T) {
+	fake := setupSpyCache(t, "", "", "")
+	spy := fake.add(1, "a", 1)
+	fake.add(2, "b", 2)
+
+	lookup(t, "a", "a")
+	lookup(t, "b", "b")
+
+	// an update of the spy
+	name := "renamed"
+	applySpyUpdate(&spy, requestmodels.UpdateSpyRequest{Name: &name})
+	fake.spies["a"] = spy
+	invalidateSpy(spy)
+	lookup(t, "a", name)
+
+	// a rotation of its token, the old one being unknown from then on
+	lookup(t, "new", "")
+	delete(fake.spies, "a")
+	spy.Token = "new"
+	fake.spies["new"] = spy
+	invalidateSpy(spy)
+	lookup(t, "new", name)
+	lookup(t, "a", "")
+
+	// an update of the owner settings
+	loads := fake.loads["new"]
+	invalidateUserSpies(1)
+	lookup(t, "new", name)
+	lookup(t, "b", "b")
+	if fake.loads["new"] != loads+1 || fake.loads["b"] != 1 {
+		t.Errorf("loads = %v, want only the spies of the user loaded again", fake.loads)
+	}
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/models"
+	requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+)
+
+// UpdateSettings updates the settings of a user. The IP mode only applies to
+// the records created afterwards.
+func UpdateSettings(req requestmodels.UpdateSettingsRequest, userId uint) error {
+	result := database.Db.Model(&models.User{}).Where("id = ?", userId).Update("ip_mode", req.IpMode)
+	if result.Error != nil {
+		return ServiceError{
+			Code:    500,
+			Message: "Error while updating settings: " + result.Error.Error(),
+		}
+	}
+	if result.RowsAffected == 0 {
+		return ServiceError{
+			Code:    404,
+			Message: "User not found",
+		}
+	}
+
+	invalidateUserSpies(userId)
+
+	return nil
+}
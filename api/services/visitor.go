@@ -0,0 +1,147 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/database"
+	"github.com/ZiplEix/pixel-espion/models"
+	"gorm.io/gorm"
+)
+
+// defaultDedupWindow is the number of minutes during which the hits of a
+// visitor are repeats of the previous one, unless the spy sets its own.
+const defaultDedupWindow = 30
+
+// visitorKey identifies the reader behind a record. It is keyed by the pixel
+// secret so that it can't be reversed into an IP address, and by the salt when
+// given, so that it can't link the visits of a reader across days either.
+func visitorKey(ip string, userAgent string, recipient string, salt []byte) string {
+	key := append([]byte(os.Getenv("PIXEL_SECRET")), salt...)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ip))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userAgent))
+	mac.Write([]byte{0})
+	mac.Write([]byte(recipient))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// maxLastVisits bounds the number of visitors remembered by lastVisits.
+const maxLastVisits = 100000
+
+// lastVisits remembers the time of the last record of the recent visitors,
+// keyed by spy, visitor key and event type. Records are stored in batches, so
+// the previous record of a visitor may not be in the database yet.
+var lastVisits = struct {
+	sync.Mutex
+	times map[string]time.Time
+}{times: make(map[string]time.Time)}
+
+// previousVisit returns the time of the previous record of the same visitor
+// and event type.
+func previousVisit(record *models.Record) (time.Time, bool, error) {
+	key := fmt.Sprintf("%d/%s/%s", record.SpyID, record.VisitorKey, record.EventType)
+
+	lastVisits.Lock()
+	last, ok := lastVisits.times[key]
+	if len(lastVisits.times) >= maxLastVisits {
+		lastVisits.times = make(map[string]time.Time)
+	}
+	lastVisits.times[key] = record.Time
+	lastVisits.Unlock()
+	if ok {
+		return last, true, nil
+	}
+
+	var previous models.Record
+	err := database.Db.Select("time").
+		Where("spy_id = ? AND visitor_key = ? AND event_type = ?", record.SpyID, record.VisitorKey, record.EventType).
+		Order("time DESC").
+		First(&previous).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	return previous.Time, true, nil
+}
+
+// enrichVisitorKey computes the visitor key of the record, from its address in
+// clear. Unless the IP mode keeps the addresses in full, the key changes with
+// the daily salt, and is left empty if the salt can't be read. A record without
+// an address gets no key.
+func enrichVisitorKey(record *models.Record, mode string) {
+	if record.Ip == "" {
+		return
+	}
+
+	var salt []byte
+	if mode != models.IpFull {
+		var err error
+		salt, err = dailySalt()
+		if err != nil {
+			fmt.Printf("Error while reading the daily salt: %v\n", err)
+			return
+		}
+	}
+
+	userAgent := ""
+	if record.UserAgent != nil {
+		userAgent = *record.UserAgent
+	}
+	record.VisitorKey = visitorKey(record.Ip, userAgent, record.Recipient, salt)
+}
+
+// enrichVisit computes the visitor key of the record, unless it already has
+// one, and compares it to the previous record of the same visitor and event
+// type. A record without a key is left unclassified.
+func enrichVisit(record *models.Record, spy models.Spy) {
+	if record.VisitorKey == "" {
+		enrichVisitorKey(record, ipMode(spy))
+	}
+	if record.VisitorKey == "" {
+		return
+	}
+
+	previous, ok, err := previousVisit(record)
+	if err != nil {
+		// leave the record unclassified rather than losing it
+		return
+	}
+	if !ok {
+		record.Visit = models.VisitFirst
+		return
+	}
+
+	window := time.Duration(spy.DedupWindow) * time.Minute
+	if window == 0 {
+		window = defaultDedupWindow * time.Minute
+	}
+	if record.Time.Sub(previous) < window {
+		record.Visit = models.VisitRepeat
+	} else {
+		record.Visit = models.VisitReopen
+	}
+}
+
+// withOpenCounts selects the open counters of the spies along with them, from
+// the daily rollups. The total counts every open, the others leave the
+// suspicious records out, the unique count being the number of first visits.
+func withOpenCounts(query *gorm.DB) *gorm.DB {
+	return query.Select(
+		"spies.*, "+
+			"(SELECT COALESCE(SUM(total), 0) FROM daily_rollups WHERE daily_rollups.spy_id = spies.id AND daily_rollups.event_type = ?) AS total_opens, "+
+			"(SELECT COALESCE(SUM(human), 0) FROM daily_rollups WHERE daily_rollups.spy_id = spies.id AND daily_rollups.event_type = ?) AS opens, "+
+			"(SELECT COALESCE(SUM(uniques), 0) FROM daily_rollups WHERE daily_rollups.spy_id = spies.id AND daily_rollups.event_type = ?) AS unique_opens",
+		models.EventOpen, models.EventOpen, models.EventOpen,
+	)
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+)
+
+// The standard library has no WebP encoder, but a pixel is a single color,
+// which the lossless (VP8L) format can describe without any pixel data: every
+// prefix code only has one symbol, so each pixel is decoded with zero bits.
+
+type bitWriter struct {
+	buf   bytes.Buffer
+	acc   uint64
+	nbits uint
+}
+
+func (w *bitWriter) write(value uint64, n uint) {
+	w.acc |= value << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf.WriteByte(byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf.WriteByte(byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.buf.Bytes()
+}
+
+// writeSimpleCode writes a prefix code holding a single symbol.
+func (w *bitWriter) writeSimpleCode(symbol uint8) {
+	w.write(1, 1) // simple code
+	w.write(0, 1) // one symbol
+	if symbol < 2 {
+		w.write(0, 1)
+		w.write(uint64(symbol), 1)
+	} else {
+		w.write(1, 1)
+		w.write(uint64(symbol), 8)
+	}
+}
+
+func encodeWebP(c color.NRGBA, width, height int) []byte {
+	var w bitWriter
+
+	w.write(0x2f, 8) // VP8L signature
+	w.write(uint64(width-1), 14)
+	w.write(uint64(height-1), 14)
+	if c.A != 0xff {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	w.write(0, 3) // version
+
+	w.write(0, 1) // no transform
+	w.write(0, 1) // no color cache
+	w.write(0, 1) // no meta prefix codes
+
+	w.writeSimpleCode(c.G)
+	w.writeSimpleCode(c.R)
+	w.writeSimpleCode(c.B)
+	w.writeSimpleCode(c.A)
+	w.writeSimpleCode(0) // distance
+
+	data := w.bytes()
+
+	chunkSize := len(data)
+	padded := chunkSize + chunkSize%2
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(4+8+padded))
+	out.WriteString("WEBP")
+	out.WriteString("VP8L")
+	binary.Write(&out, binary.LittleEndian, uint32(chunkSize))
+	out.Write(data)
+	if chunkSize%2 == 1 {
+		out.WriteByte(0)
+	}
+
+	return out.Bytes()
+}
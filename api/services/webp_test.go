@@ -0,0 +1,83 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebP(t *testing.T) {
+	tests := []struct {
+		name   string
+		color  color.NRGBA
+		width  int
+		height int
+	}{
+		{"opaque pixel", color.NRGBA{R: 0xff, G: 0x00, B: 0x00, A: 0xff}, 1, 1},
+		{"transparent pixel", color.NRGBA{A: 0x00}, 1, 1},
+		{"low symbols", color.NRGBA{R: 1, G: 0, B: 1, A: 0xff}, 1, 1},
+		{"rectangle", color.NRGBA{R: 0x12, G: 0x34, B: 0x56, A: 0x78}, 100, 37},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeWebP(tt.color, tt.width, tt.height)
+
+			if len(data) < 25 || len(data)%2 != 0 {
+				t.Fatalf("encodeWebP() is %d bytes long", len(data))
+			}
+			if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" || string(data[12:16]) != "VP8L" {
+				t.Fatalf("encodeWebP() headers = %q", data[:16])
+			}
+			if size := binary.LittleEndian.Uint32(data[4:8]); int(size) != len(data)-8 {
+				t.Errorf("RIFF size = %d, want %d", size, len(data)-8)
+			}
+			chunkSize := int(binary.LittleEndian.Uint32(data[16:20]))
+			if padded := chunkSize + chunkSize%2; padded != len(data)-20 {
+				t.Errorf("VP8L chunk size = %d for %d bytes of data", chunkSize, len(data)-20)
+			}
+
+			// signature, then 14 bits of width - 1, 14 bits of height - 1 and
+			// the alpha hint
+			if data[20] != 0x2f {
+				t.Fatalf("VP8L signature = %#x", data[20])
+			}
+			bits := binary.LittleEndian.Uint32(data[21:25])
+			width := int(bits&0x3fff) + 1
+			height := int(bits>>14&0x3fff) + 1
+			alpha := bits>>28&1 == 1
+			if width != tt.width || height != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", width, height, tt.width, tt.height)
+			}
+			if alpha != (tt.color.A != 0xff) {
+				t.Errorf("alpha hint = %v for alpha %d", alpha, tt.color.A)
+			}
+			if version := bits >> 29; version != 0 {
+				t.Errorf("version = %d, want 0", version)
+			}
+
+			img, err := webp.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("webp.Decode() error = %v", err)
+			}
+			if bounds := img.Bounds(); bounds != image.Rect(0, 0, tt.width, tt.height) {
+				t.Errorf("decoded bounds = %v, want %dx%d", bounds, tt.width, tt.height)
+			}
+			for _, p := range []image.Point{{0, 0}, {tt.width - 1, tt.height - 1}, {tt.width / 2, tt.height / 2}} {
+				got := color.NRGBAModel.Convert(img.At(p.X, p.Y)).(color.NRGBA)
+				want := tt.color
+				if want.A == 0 {
+					// the color of a transparent pixel doesn't matter
+					got, want = color.NRGBA{A: got.A}, color.NRGBA{}
+				}
+				if got != want {
+					t.Errorf("decoded pixel at %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+// Package spool keeps entries on the local disk in append-only files, for
+// them to be replayed later. Entries are lines of bytes (such as JSON
+// documents) written to the current file, which is rotated once it grows over
+// the maximum size. Only rotated files are replayed.
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZiplEix/pixel-espion/env"
+)
+
+const (
+	defaultDir     = "spooled"
+	defaultMaxSize = 16 << 20
+
+	currentName = "current.spool"
+	extension   = ".spool"
+)
+
+// maxLineSize bounds the size of an entry read back.
+const maxLineSize = 1 << 20
+
+var state struct {
+	sync.Mutex
+	enabled bool
+	maxSize int64
+	current *os.File
+	size    int64
+}
+
+// File is a spool file, the current one or a rotated one.
+type File struct {
+	Name    string
+	Size    int64
+	Entries int
+	Current bool
+	ModTime time.Time
+}
+
+// Dir returns the directory of the spool files, set by the SPOOL_DIR env var.
+func Dir() string {
+	if dir := os.Getenv("SPOOL_DIR"); dir != "" {
+		return dir
+	}
+	return defaultDir
+}
+
+// Setup opens the spool directory for writing, the size in bytes over which
+// the current file is rotated being set by the SPOOL_MAX_SIZE env var. A
+// current file left by a previous run is rotated right away.
+func Setup() error {
+	fmt.Println("Setting up spool...")
+
+	dir := Dir()
+	maxSize, err := env.Int("SPOOL_MAX_SIZE", defaultMaxSize)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	state.Lock()
+	defer state.Unlock()
+
+	state.enabled = true
+	state.maxSize = int64(maxSize)
+
+	return rotate()
+}
+
+// Enabled reports whether Setup was called, entries can only be appended
+// then.
+func Enabled() bool {
+	state.Lock()
+	defer state.Unlock()
+
+	return state.enabled
+}
+
+// Append writes the entries to the current file, and only returns once they
+// are synced to the disk. An entry must not contain a newline.
+func Append(entries ...[]byte) error {
+	state.Lock()
+	defer state.Unlock()
+
+	if !state.enabled {
+		return errors.New("spool is not set up")
+	}
+
+	if state.current == nil {
+		f, err := os.OpenFile(filepath.Join(Dir(), currentName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		state.current = f
+		state.size = info.Size()
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		if bytes.IndexByte(entry, '\n') >= 0 {
+			return errors.New("spool entries can't contain a newline")
+		}
+		buf.Write(entry)
+		buf.WriteByte('\n')
+	}
+
+	n, err := state.current.Write(buf.Bytes())
+	state.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := state.current.Sync(); err != nil {
+		return err
+	}
+
+	if state.size >= state.maxSize {
+		return rotate()
+	}
+
+	return nil
+}
+
+// Rotate closes the current file, so that its entries can be replayed.
+func Rotate() error {
+	state.Lock()
+	defer state.Unlock()
+
+	if !state.enabled {
+		return errors.New("spool is not set up")
+	}
+
+	return rotate()
+}
+
+// rotate renames the current file after the time it is rotated at, so the
+// rotated files sort in the order they were written. The lock must be held.
+func rotate() error {
+	if state.current != nil {
+		if err := state.current.Close(); err != nil {
+			return err
+		}
+		state.current = nil
+		state.size = 0
+	}
+
+	dir := Dir()
+	path := filepath.Join(dir, currentName)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return os.Remove(path)
+	}
+
+	rotated := filepath.Join(dir, strconv.FormatInt(time.Now().UnixNano(), 10)+extension)
+	if err := os.Rename(path, rotated); err != nil {
+		return err
+	}
+
+	// make the rename durable as well
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// Rotated returns the paths of the rotated files, oldest first.
+func Rotated() ([]string, error) {
+	dir := Dir()
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == currentName || !strings.HasSuffix(name, extension) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// Read calls fn with each entry of a file, in order. A truncated last line,
+// such as the one of a crash while writing, is given as is.
+func Read(path string, fn func(entry []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Remove deletes a rotated file, once all of its entries are replayed.
+func Remove(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// replayed by another process
+		return nil
+	}
+
+	return err
+}
+
+// Status lists the spool files, the rotated ones oldest first then the
+// current one. It can be used from another process than the one writing to
+// the spool.
+func Status() ([]File, error) {
+	dir := Dir()
+	paths, err := Rotated()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, currentName)); err == nil {
+		paths = append(paths, filepath.Join(dir, currentName))
+	}
+
+	files := make([]File, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// replayed in the meantime
+				continue
+			}
+			return nil, err
+		}
+
+		file := File{
+			Name:    filepath.Base(path),
+			Size:    info.Size(),
+			Current: filepath.Base(path) == currentName,
+			ModTime: info.ModTime(),
+		}
+		err = Read(path, func([]byte) error {
+			file.Entries++
+			return nil
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,222 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// setup sets the spool up in a directory of the test, and closes it after.
+func setup(t *testing.T, maxSize string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("SPOOL_DIR", dir)
+	t.Setenv("SPOOL_MAX_SIZE", maxSize)
+	if err := Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		state.Lock()
+		defer state.Unlock()
+
+		if state.current != nil {
+			state.current.Close()
+		}
+		state.enabled = false
+		state.current = nil
+		state.size = 0
+	})
+
+	return dir
+}
+
+// readAll returns the entries of the rotated files, oldest first.
+func readAll(t *testing.T) []string {
+	t.Helper()
+
+	paths, err := Rotated()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []string
+	for _, path := range paths {
+		err := Read(path, func(entry []byte) error {
+			entries = append(entries, string(entry))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return entries
+}
+
+func TestAppendRotateRead(t *testing.T) {
+	setup(t, "")
+
+	if err := Append([]byte("first"), []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append([]byte("third")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t); got != nil {
+		t.Fatalf("entries of the current file replayable before rotation: %q", got)
+	}
+
+	if err := Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append([]byte("fourth")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"first", "second", "third", "fourth"}
+	if got := readAll(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+
+	paths, _ := Rotated()
+	if len(paths) != 2 {
+		t.Fatalf("%d rotated files, want 2", len(paths))
+	}
+	for _, path := range paths {
+		if err := Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Remove(paths[0]); err != nil {
+		t.Errorf("Remove() = %v for a file removed already", err)
+	}
+	if got := readAll(t); got != nil {
+		t.Errorf("entries = %q after removal", got)
+	}
+}
+
+func TestRotateEmpty(t *testing.T) {
+	dir := setup(t, "")
+
+	if err := Append(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, _ := os.ReadDir(dir)
+	if len(names) != 0 {
+		t.Errorf("%d files left, want the empty current file removed", len(names))
+	}
+}
+
+func TestAppendRotatesOverMaxSize(t *testing.T) {
+	setup(t, "10")
+
+	for _, entry := range []string{"12345", "67890", "abc"} {
+		if err := Append([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, _ := Rotated()
+	if len(paths) != 1 {
+		t.Fatalf("%d rotated files, want 1", len(paths))
+	}
+	want := []string{"12345", "67890"}
+	if got := readAll(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	if err := Append([]byte("entry")); err == nil {
+		t.Error("Append() = nil before Setup")
+	}
+	if err := Rotate(); err == nil {
+		t.Error("Rotate() = nil before Setup")
+	}
+
+	setup(t, "")
+	if err := Append([]byte("two\nlines")); err == nil {
+		t.Error("Append() = nil for an entry with a newline")
+	}
+}
+
+func TestSetupRotatesLeftover(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, currentName), []byte("left\nover\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SPOOL_DIR", dir)
+	if err := Setup(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		state.Lock()
+		state.enabled = false
+		state.Unlock()
+	})
+
+	want := []string{"left", "over"}
+	if got := readAll(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1"+extension)
+	if err := os.WriteFile(path, []byte("first\n\nsecond\ntrunc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err := Read(path, func(entry []byte) error {
+		got = append(got, string(entry))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"first", "second", "trunc"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	setup(t, "")
+
+	if err := Append([]byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append([]byte("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Status() = %d files, want 2", len(files))
+	}
+	if files[0].Current || files[0].Entries != 2 || files[0].Size != 4 {
+		t.Errorf("rotated file = %+v, want 2 entries in 4 bytes", files[0])
+	}
+	if !files[1].Current || files[1].Entries != 1 || files[1].Name != currentName {
+		t.Errorf("current file = %+v, want 1 entry", files[1])
+	}
+}
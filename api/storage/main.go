@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrNotFound is returned when no object is stored under a key.
+var ErrNotFound = errors.New("object not found")
+
+// Storage keeps the files uploaded by the users, such as the custom images of
+// the spies.
+type Storage interface {
+	Save(key string, data []byte) error
+	Load(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+var Store Storage
+
+func Setup() error {
+	fmt.Println("Setting up storage...")
+
+	dir, ok := os.LookupEnv("STORAGE_DIR")
+	if !ok || dir == "" {
+		dir = "uploads"
+	}
+
+	local, err := NewLocal(dir)
+	if err != nil {
+		return fmt.Errorf("failed to setup storage: %w", err)
+	}
+	Store = local
+
+	return nil
+}
@@ -0,0 +1,124 @@
+// Package useragent splits user agents into browser, OS, device class and
+// email client, following the rules of the embedded rules.json file.
+package useragent
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Device classes.
+const (
+	Desktop = "desktop"
+	Mobile  = "mobile"
+	Tablet  = "tablet"
+	Bot     = "bot"
+)
+
+type Info struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	OSVersion      string
+	Device         string
+	EmailClient    string
+}
+
+// rule matches a user agent against its pattern, unless it also matches the
+// optional exclude pattern. The first group of the pattern, if any, is the
+// version.
+type rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Exclude string `json:"exclude"`
+
+	pattern *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func (r *rule) compile() error {
+	var err error
+
+	r.pattern, err = regexp.Compile(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern of rule '%s': %w", r.Name, err)
+	}
+
+	if r.Exclude != "" {
+		r.exclude, err = regexp.Compile(r.Exclude)
+		if err != nil {
+			return fmt.Errorf("invalid exclude pattern of rule '%s': %w", r.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *rule) match(ua string) (bool, string) {
+	m := r.pattern.FindStringSubmatch(ua)
+	if m == nil || (r.exclude != nil && r.exclude.MatchString(ua)) {
+		return false, ""
+	}
+
+	if len(m) > 1 {
+		return true, strings.ReplaceAll(m[1], "_", ".")
+	}
+	return true, ""
+}
+
+type rules struct {
+	Browsers     []*rule `json:"browsers"`
+	OS           []*rule `json:"os"`
+	Devices      []*rule `json:"devices"`
+	EmailClients []*rule `json:"email_clients"`
+}
+
+//go:embed rules.json
+var rulesFile []byte
+
+var parser = mustLoad(rulesFile)
+
+func mustLoad(data []byte) *rules {
+	var r rules
+	if err := json.Unmarshal(data, &r); err != nil {
+		panic(fmt.Errorf("invalid user agent rules: %w", err))
+	}
+
+	for _, list := range [][]*rule{r.Browsers, r.OS, r.Devices, r.EmailClients} {
+		for _, rule := range list {
+			if err := rule.compile(); err != nil {
+				panic(fmt.Errorf("invalid user agent rules: %w", err))
+			}
+		}
+	}
+
+	return &r
+}
+
+func firstMatch(list []*rule, ua string) (string, string) {
+	for _, rule := range list {
+		if ok, version := rule.match(ua); ok {
+			return rule.Name, version
+		}
+	}
+	return "", ""
+}
+
+// Parse returns what the rules tell about a user agent. Fields are left empty
+// when no rule matches.
+func Parse(ua string) Info {
+	var info Info
+	if ua == "" {
+		return info
+	}
+
+	info.Browser, info.BrowserVersion = firstMatch(parser.Browsers, ua)
+	info.OS, info.OSVersion = firstMatch(parser.OS, ua)
+	info.Device, _ = firstMatch(parser.Devices, ua)
+	info.EmailClient, _ = firstMatch(parser.EmailClients, ua)
+
+	return info
+}
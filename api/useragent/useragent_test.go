@@ -0,0 +1,127 @@
+package useragent
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want Info
+	}{
+		{
+			name: "empty",
+			ua:   "",
+			want: Info{},
+		},
+		{
+			name: "chrome on windows",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.60 Safari/537.36",
+			want: Info{Browser: "Chrome", BrowserVersion: "124.0.6367.60", OS: "Windows", OSVersion: "10.0", Device: Desktop},
+		},
+		{
+			name: "edge before chrome",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.51",
+			want: Info{Browser: "Edge", BrowserVersion: "124.0.2478.51", OS: "Windows", OSVersion: "10.0", Device: Desktop},
+		},
+		{
+			name: "firefox on linux",
+			ua:   "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			want: Info{Browser: "Firefox", BrowserVersion: "125.0", OS: "Linux", Device: Desktop},
+		},
+		{
+			name: "safari on macos",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15",
+			want: Info{Browser: "Safari", BrowserVersion: "17.4.1", OS: "macOS", OSVersion: "10.15.7", Device: Desktop},
+		},
+		{
+			name: "safari on iphone",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			want: Info{Browser: "Safari", BrowserVersion: "17.4", OS: "iOS", OSVersion: "17.4", Device: Mobile},
+		},
+		{
+			name: "chrome on android phone",
+			ua:   "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.82 Mobile Safari/537.36",
+			want: Info{Browser: "Chrome", BrowserVersion: "124.0.6367.82", OS: "Android", OSVersion: "14", Device: Mobile},
+		},
+		{
+			name: "android tablet",
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-X710) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.82 Safari/537.36",
+			want: Info{Browser: "Chrome", BrowserVersion: "124.0.6367.82", OS: "Android", OSVersion: "13", Device: Tablet},
+		},
+		{
+			name: "samsung internet",
+			ua:   "Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36",
+			want: Info{Browser: "Samsung Internet", BrowserVersion: "24.0", OS: "Android", OSVersion: "14", Device: Mobile},
+		},
+		{
+			name: "bot",
+			ua:   "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want: Info{Device: Bot},
+		},
+		{
+			name: "http library",
+			ua:   "curl/8.5.0",
+			want: Info{Device: Bot},
+		},
+		{
+			name: "gmail image proxy",
+			ua:   "Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com GoogleImageProxy)",
+			want: Info{Browser: "Firefox", BrowserVersion: "11.0", OS: "Windows", OSVersion: "5.1", Device: Desktop, EmailClient: "Gmail"},
+		},
+		{
+			name: "outlook",
+			ua:   "Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 10.0; Microsoft Outlook 16.0.17531; ms-office; MSOffice 16)",
+			want: Info{Browser: "Internet Explorer", BrowserVersion: "7.0", OS: "Windows", OSVersion: "10.0", Device: Desktop, EmailClient: "Outlook"},
+		},
+		{
+			name: "thunderbird",
+			ua:   "Mozilla/5.0 (X11; Linux x86_64; rv:115.0) Gecko/20100101 Thunderbird/115.10.1",
+			want: Info{Browser: "Thunderbird", BrowserVersion: "115.10.1", OS: "Linux", Device: Desktop, EmailClient: "Thunderbird"},
+		},
+		{
+			name: "apple mail on macos",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko)",
+			want: Info{Browser: "WebKit", BrowserVersion: "605.1.15", OS: "macOS", OSVersion: "10.15.7", Device: Desktop, EmailClient: "Apple Mail"},
+		},
+		{
+			name: "apple mail on iphone",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148",
+			want: Info{Browser: "WebKit", BrowserVersion: "605.1.15", OS: "iOS", OSVersion: "17.4", Device: Mobile, EmailClient: "Apple Mail"},
+		},
+		{
+			name: "unknown",
+			ua:   "SomeClient",
+			want: Info{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.ua); got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.ua, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMustLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"invalid json", `{`},
+		{"invalid pattern", `{"browsers": [{"name": "broken", "pattern": "("}]}`},
+		{"invalid exclude", `{"devices": [{"name": "broken", "pattern": "a", "exclude": "("}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("mustLoad() didn't panic")
+				}
+			}()
+			mustLoad([]byte(tt.data))
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package validation
+
+import requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+
+func NewLink(req requestmodels.NewLinkRequest) error {
+	return validate.Struct(req)
+}
+
+func UpdateLink(req requestmodels.UpdateLinkRequest) error {
+	return validate.Struct(req)
+}
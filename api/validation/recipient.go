@@ -0,0 +1,11 @@
+package validation
+
+import requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+
+func AttachRecipients(req requestmodels.AttachRecipientsRequest) error {
+	return validate.Struct(req)
+}
+
+func RecipientUrls(req requestmodels.RecipientUrlsRequest) error {
+	return validate.Struct(req)
+}
@@ -0,0 +1,7 @@
+package validation
+
+import requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+
+func RecordFilter(req requestmodels.RecordFilter) error {
+	return validate.Struct(req)
+}
@@ -5,3 +5,11 @@ import requestmodels "github.com/ZiplEix/pixel-espion/request_models"
 func NewSpy(req requestmodels.NewSpyRequest) error {
 	return validate.Struct(req)
 }
+
+func UpdateSpy(req requestmodels.UpdateSpyRequest) error {
+	return validate.Struct(req)
+}
+
+func SignedUrl(req requestmodels.SignedUrlRequest) error {
+	return validate.Struct(req)
+}
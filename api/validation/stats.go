@@ -0,0 +1,7 @@
+package validation
+
+import requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+
+func Stats(req requestmodels.StatsRequest) error {
+	return validate.Struct(req)
+}
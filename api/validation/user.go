@@ -0,0 +1,7 @@
+package validation
+
+import requestmodels "github.com/ZiplEix/pixel-espion/request_models"
+
+func UpdateSettings(req requestmodels.UpdateSettingsRequest) error {
+	return validate.Struct(req)
+}